@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/anupam111/concurrent-downloader/internal/download"
+)
+
+// config is the parsed command line of the downloader.
+type config struct {
+	opts download.DownloadOptions
+	urls []string
+	json bool
+	// output is the file the single url is written to, "-" for stdout.
+	output string
+}
+
+// urlList collects the values of a repeated -url flag.
+type urlList []string
+
+func (u *urlList) String() string {
+	return strings.Join(*u, ",")
+}
+
+func (u *urlList) Set(v string) error {
+	*u = append(*u, v)
+	return nil
+}
+
+// parseFlags parses args (without the program name) into a config. Usage
+// and errors are written to output.
+func parseFlags(args []string, output io.Writer) (config, error) {
+	fs := flag.NewFlagSet("concurrent-downloader", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprintf(output, "Usage: concurrent-downloader [flags] [url ...]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	var cfg config
+	var urls urlList
+	var input string
+	fs.StringVar(&cfg.opts.DownloadDir, "dir", ".", "directory to download the files into")
+	fs.IntVar(&cfg.opts.NumConcParts, "parts", 2, "number of parts a file > 10MB is split into")
+	fs.IntVar(&cfg.opts.MaxLimitConcurrency, "concurrency", 5, "max number of files downloaded simultaneously")
+	fs.Var(&urls, "url", "url to download, can be repeated")
+	fs.StringVar(&input, "input", "", "file with one url per line, blank lines and # comments are skipped")
+	fs.StringVar(&cfg.opts.Proxy, "proxy", "", "http, https or socks5 proxy url, defaults to $HTTP_PROXY/$HTTPS_PROXY")
+	fs.BoolVar(&cfg.opts.Verbose, "verbose", false, "print the progress of every part")
+	fs.BoolVar(&cfg.json, "json", false, "print the results as a JSON array on stdout")
+	fs.StringVar(&cfg.output, "o", "", "write the single url to this file instead of -dir, - for stdout")
+
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+	cfg.urls = append(urls, fs.Args()...)
+	if input != "" {
+		fileUrls, err := readInput(input)
+		if err != nil {
+			fmt.Fprintln(output, err)
+			return config{}, err
+		}
+		cfg.urls = append(cfg.urls, fileUrls...)
+	}
+
+	if err := validate(cfg); err != nil {
+		fmt.Fprintln(output, err)
+		fs.Usage()
+		return config{}, err
+	}
+	if cfg.json || cfg.output == "-" {
+		// Keep the progress messages out of the JSON or the downloaded bytes.
+		cfg.opts.Output = os.Stderr
+	}
+	return cfg, nil
+}
+
+// readInput reads the urls of the -input file.
+func readInput(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening input file: %w", err)
+	}
+	defer f.Close()
+	return download.ReadURLs(f)
+}
+
+// validate checks the parsed flags for values the downloader can't work with.
+func validate(cfg config) error {
+	switch {
+	case len(cfg.urls) == 0:
+		return errors.New("at least one url is required")
+	case cfg.opts.DownloadDir == "":
+		return errors.New("-dir must not be empty")
+	case cfg.opts.NumConcParts < 1:
+		return fmt.Errorf("-parts must be at least 1, got %d", cfg.opts.NumConcParts)
+	case cfg.output != "" && len(cfg.urls) != 1:
+		return fmt.Errorf("-o needs exactly one url, got %d", len(cfg.urls))
+	case cfg.output != "" && cfg.json:
+		return errors.New("-o and -json can't be combined")
+	case cfg.opts.MaxLimitConcurrency < 1:
+		return fmt.Errorf("-concurrency must be at least 1, got %d", cfg.opts.MaxLimitConcurrency)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	cfg, err := parseFlags([]string{"-dir", "/tmp/out", "-parts", "4", "-concurrency", "3", "-url", "http://example.com/a", "-url", "http://example.com/b", "http://example.com/c"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.opts.DownloadDir != "/tmp/out" || cfg.opts.NumConcParts != 4 || cfg.opts.MaxLimitConcurrency != 3 {
+		t.Errorf("parseFlags() options = %+v, want -dir, -parts and -concurrency applied", cfg.opts)
+	}
+	want := []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}
+	if !reflect.DeepEqual(cfg.urls, want) {
+		t.Errorf("urls = %q, want %q", cfg.urls, want)
+	}
+
+	cfg, err = parseFlags([]string{"http://example.com/a"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.opts.DownloadDir != "." || cfg.opts.NumConcParts != 2 || cfg.opts.MaxLimitConcurrency != 5 {
+		t.Errorf("parseFlags() defaults = %+v", cfg.opts)
+	}
+}
+
+func TestParseFlagsInvalid(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "at least one url"},
+		{[]string{"-dir", "", "http://example.com/a"}, "-dir must not be empty"},
+		{[]string{"-parts", "0", "http://example.com/a"}, "-parts must be at least 1"},
+		{[]string{"-concurrency", "-1", "http://example.com/a"}, "-concurrency must be at least 1"},
+		{[]string{"-parts", "many", "http://example.com/a"}, "invalid value"},
+		{[]string{"-unknown", "http://example.com/a"}, "not defined"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if _, err := parseFlags(tt.args, &out); err == nil {
+			t.Errorf("parseFlags(%q) succeeded", tt.args)
+			continue
+		}
+		if !strings.Contains(out.String(), tt.want) || !strings.Contains(out.String(), "Usage:") {
+			t.Errorf("parseFlags(%q) printed %q, want %q and the usage", tt.args, out.String(), tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	if _, err := parseFlags([]string{"-h"}, &out); !errors.Is(err, flag.ErrHelp) || !strings.Contains(out.String(), "-parts") {
+		t.Errorf("parseFlags(-h) = %v, printed %q, want flag.ErrHelp and the usage", err, out.String())
+	}
+}
+
+func TestParseFlagsInput(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "urls.txt")
+	if err := os.WriteFile(input, []byte("# batch\nhttp://example.com/b\n\n  http://example.com/c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseFlags([]string{"-input", input, "http://example.com/a"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}
+	if !reflect.DeepEqual(cfg.urls, want) {
+		t.Errorf("urls = %q, want %q", cfg.urls, want)
+	}
+
+	if _, err := parseFlags([]string{"-input", input + ".missing"}, io.Discard); err == nil {
+		t.Error("parseFlags() with a missing -input file succeeded")
+	}
+}
@@ -1,32 +1,115 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/anupam111/concurrent-downloader/internal/download"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/anupam111/concurrent-downloader/internal/download"
 )
 
 func main() {
-	fileUrls := []string{
-	"https://www.eurofound.europa.eu/sites/default/files/ef_publication/field_ef_document/ef1710en.pdf",                                                                                                                                                  //research report 5mb
-	"https://www.stats.govt.nz/assets/Uploads/Annual-enterprise-survey/Annual-enterprise-survey-2021-financial-year-provisional/Download-data/annual-enterprise-survey-2021-financial-year-provisional-csv.csv",                                          //6.3 MB
-	"https://www.stats.govt.nz/assets/Uploads/New-Zealand-business-demography-statistics/New-Zealand-business-demography-statistics-At-February-2021/Download-data/Geographic-units-by-industry-and-statistical-area-2000-2021-descending-order-CSV.zip", // GEO Units (23 MB)
-	"https://www.stats.govt.nz/assets/Uploads/International-trade/International-trade-June-2022-quarter/Download-data/overseas-trade-indexes-June-2022-quarter-provisional-csv.csv",                                                                      //Overseas trade indexes, 21 MB
+	cfg, err := parseFlags(os.Args[1:], os.Stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
 	}
 
-	downloadDir := "C:\\GIT\\personal-project\\downloaddir"  // It needs to be changed accordingly.
+	ctx, stop := interruptContext()
+	defer stop()
+
+	downloader := download.NewDownloader(cfg.opts)
 
-	opts := download.DownloadOptions{
-		DownloadDir:  downloadDir,
-		NumConcParts: 2,
-		MaxLimitConcurrency: 5,
+	if cfg.output != "" {
+		if err := writeOutput(ctx, downloader, cfg.urls[0], cfg.output, os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
 	}
 
-	downloader := download.NewDownloader(opts)
-	files, err := downloader.Download(fileUrls...)
+	if cfg.json {
+		results, err := downloader.DownloadAll(ctx, cfg.urls...)
+		if werr := writeJSON(os.Stdout, results); werr != nil {
+			log.Fatalln(werr)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	files, err := downloader.DownloadContext(ctx, cfg.urls...)
 	if err != nil {
+		if ctx.Err() != nil {
+			log.Println("interrupted, in-flight downloads were cancelled")
+		}
 		log.Fatalln(err)
 		return
 	}
 	fmt.Println(files)
 }
+
+// interruptContext returns a context cancelled on Ctrl-C or SIGTERM, so that
+// the in-flight downloads stop and their temp files get cleaned up.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// jsonResult is the -json representation of a download.DownloadResult.
+type jsonResult struct {
+	URL    string `json:"url"`
+	Status string `json:"status"`
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size"`
+	// Duration is in seconds.
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// writeJSON writes results as an indented JSON array to w.
+func writeJSON(w io.Writer, results []download.DownloadResult) error {
+	out := make([]jsonResult, 0, len(results))
+	for _, r := range results {
+		jr := jsonResult{
+			URL:      r.URL,
+			Status:   r.Status.String(),
+			Path:     r.Path,
+			Size:     r.Size,
+			Duration: r.Duration.Seconds(),
+		}
+		if r.Err != nil {
+			jr.Error = r.Err.Error()
+		}
+		out = append(out, jr)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// writeOutput downloads url into the file path, or to stdout for "-".
+func writeOutput(ctx context.Context, downloader *download.Downloader, url, path string, stdout io.Writer) error {
+	if path == "-" {
+		_, err := downloader.DownloadTo(ctx, url, stdout)
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error while creating output file: %w", err)
+	}
+	if _, err := downloader.DownloadTo(ctx, url, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
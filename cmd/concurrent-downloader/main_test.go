@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/anupam111/concurrent-downloader/internal/download"
+)
+
+func TestWriteJSON(t *testing.T) {
+	results := []download.DownloadResult{
+		{URL: "http://example.com/a.bin", Status: download.StatusDownloaded, Path: "/tmp/a.bin", Size: 42, Duration: 1500 * time.Millisecond},
+		{URL: "http://example.com/b.bin", Status: download.StatusFailed, Err: errors.New("boom")},
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+
+	var raw []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &raw); err != nil {
+		t.Fatalf("output is not a JSON array: %v\n%s", err, buf.Bytes())
+	}
+	if len(raw) != len(results) {
+		t.Fatalf("got %d results, want %d", len(raw), len(results))
+	}
+	wantKeys := [][]string{
+		{"duration", "path", "size", "status", "url"},
+		{"duration", "error", "size", "status", "url"},
+	}
+	for i, r := range raw {
+		keys := make([]string, 0, len(r))
+		for k := range r {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, wantKeys[i]) {
+			t.Errorf("keys of result %d = %v, want %v", i, keys, wantKeys[i])
+		}
+	}
+
+	var got []jsonResult
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []jsonResult{
+		{URL: "http://example.com/a.bin", Status: download.StatusDownloaded.String(), Path: "/tmp/a.bin", Size: 42, Duration: 1.5},
+		{URL: "http://example.com/b.bin", Status: download.StatusFailed.String(), Error: "boom"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("writeJSON() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteJSONEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.TrimSpace(buf.Bytes()); string(got) != "[]" {
+		t.Errorf("writeJSON(nil) = %s, want []", got)
+	}
+}
+
+func TestJSONSendsProgressToStderr(t *testing.T) {
+	cfg, err := parseFlags([]string{"-json", "-verbose", "http://example.com/a"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.opts.Output != os.Stderr {
+		t.Errorf("Output = %v, want os.Stderr", cfg.opts.Output)
+	}
+	cfg, err = parseFlags([]string{"http://example.com/a"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.opts.Output != nil {
+		t.Errorf("Output = %v, want the default", cfg.opts.Output)
+	}
+}
+
+func TestOutputFlag(t *testing.T) {
+	tests := []struct {
+		args   []string
+		fail   bool
+		stderr bool
+	}{
+		{args: []string{"-o", "-", "http://example.com/a"}, stderr: true},
+		{args: []string{"-o", "out.bin", "http://example.com/a"}},
+		{args: []string{"-o", "-", "http://example.com/a", "http://example.com/b"}, fail: true},
+		{args: []string{"-o", "-", "-json", "http://example.com/a"}, fail: true},
+	}
+	for _, tt := range tests {
+		cfg, err := parseFlags(tt.args, io.Discard)
+		if (err != nil) != tt.fail {
+			t.Errorf("parseFlags(%q) error = %v, want error: %v", tt.args, err, tt.fail)
+			continue
+		}
+		if err == nil && (cfg.opts.Output == os.Stderr) != tt.stderr {
+			t.Errorf("parseFlags(%q) Output = %v, want os.Stderr: %v", tt.args, cfg.opts.Output, tt.stderr)
+		}
+	}
+}
+
+func TestWriteOutputToStdout(t *testing.T) {
+	content := bytes.Repeat([]byte("abc"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	cfg, err := parseFlags([]string{"-o", "-", "-verbose", "-dir", t.TempDir(), srv.URL + "/f"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	// Stands in for os.Stderr, which parseFlags picked.
+	cfg.opts.Output = &stderr
+	if err := writeOutput(context.Background(), download.NewDownloader(cfg.opts), cfg.urls[0], cfg.output, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stdout.Bytes(), content) {
+		t.Errorf("stdout has %d bytes, want exactly the %d bytes of the file", stdout.Len(), len(content))
+	}
+	if stderr.Len() == 0 {
+		t.Error("no progress messages on stderr")
+	}
+}
+
+func TestWriteOutputToFile(t *testing.T) {
+	content := []byte("file content")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "out.bin")
+	var stdout bytes.Buffer
+	d := download.NewDownloader(download.DownloadOptions{DownloadDir: t.TempDir()})
+	if err := writeOutput(context.Background(), d, srv.URL+"/f", path, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("%s = %q, %v, want %q", path, got, err, content)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("stdout got %d bytes, want none", stdout.Len())
+	}
+}
+
+func TestInterruptCancelsDownloads(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts can't be sent to the own process on windows")
+	}
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(make([]byte, 100))
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, stop := interruptContext()
+	defer stop()
+	dir := t.TempDir()
+	downloader := download.NewDownloader(download.DownloadOptions{DownloadDir: dir, Output: io.Discard})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := downloader.DownloadContext(ctx, srv.URL+"/f.bin")
+		errc <- err
+	}()
+
+	<-started
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("DownloadContext() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the interrupt didn't cancel the download")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the cancelled download left %d files behind", len(entries))
+	}
+}
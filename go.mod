@@ -1,3 +1,14 @@
 module github.com/anupam111/concurrent-downloader
 
 go 1.17
+
+require (
+	github.com/jlaffaye/ftp v0.2.0
+	golang.org/x/net v0.17.0
+	golang.org/x/sync v0.3.0
+)
+
+require (
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
+)
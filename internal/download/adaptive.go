@@ -0,0 +1,48 @@
+package download
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// adaptiveWindow is how long DownloadOptions.AdaptiveSplit measures the
+	// throughput of the single stream a file starts with.
+	adaptiveWindow = time.Second
+	// adaptiveMinRest is how long the stream must still need for the rest
+	// of the file at that throughput for splitting to pay off.
+	adaptiveMinRest = 2 * time.Second
+)
+
+// splitIfSlow waits until adaptiveWindow passed or done is closed, then
+// starts workers-1 more workers taking over parts of the single stream of
+// q when the stream would need longer than adaptiveMinRest for the rest of
+// the size bytes. It returns the number of workers.
+func (d *Downloader) splitIfSlow(ctx context.Context, g *errgroup.Group, req fileRequest, size int64, q *stealQueue, workers int, done <-chan struct{}, wrap func(io.Writer) io.Writer) int {
+	start := time.Now()
+	t := time.NewTimer(adaptiveWindow)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-done:
+		return 1
+	case <-ctx.Done():
+		return 1
+	}
+	written := q.written()
+	rate := float64(written) / time.Since(start).Seconds()
+	if rest := float64(size - written); rate > 0 && rest/rate < adaptiveMinRest.Seconds() {
+		d.printf("keeping a single stream for %s at %.0f bytes/s\n", req.url, rate)
+		return 1
+	}
+	d.printf("splitting %s into %d parts at %.0f bytes/s\n", req.url, workers, rate)
+	for i := 1; i < workers; i++ {
+		g.Go(func() error {
+			return d.stealWork(ctx, req, size, q, wrap)
+		})
+	}
+	return workers
+}
@@ -0,0 +1,85 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// throttledReader serves about rate bytes per second of every request.
+type throttledReader struct {
+	io.ReadSeeker
+	rate int
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.rate/20 {
+		p = p[:r.rate/20]
+	}
+	time.Sleep(50 * time.Millisecond)
+	return r.ReadSeeker.Read(p)
+}
+
+func TestAdaptiveSplit(t *testing.T) {
+	data := fixture(12 << 20)
+	fast := serve(map[string][]byte{"/f.bin": data})
+	defer fast.Close()
+	// At 2MB/s the rest of the file would take 5s after the first second.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, &throttledReader{ReadSeeker: bytes.NewReader(data), rate: 2 << 20})
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{"fast", fast.URL + "/f.bin", 1},
+		{"slow", slow.URL + "/f.bin", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), NumConcParts: 4, AdaptiveSplit: true})
+			results, err := d.DownloadAll(context.Background(), tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if results[0].Concurrency != tt.want {
+				t.Errorf("Concurrency = %d, want %d", results[0].Concurrency, tt.want)
+			}
+			if b, _ := os.ReadFile(results[0].Path); !bytes.Equal(b, data) {
+				t.Error("downloaded file differs")
+			}
+		})
+	}
+}
+
+// BenchmarkAdaptiveSplit compares AdaptiveSplit to always splitting a file
+// served by a fast local server, where a single stream wins.
+func BenchmarkAdaptiveSplit(b *testing.B) {
+	data := fixture(64 << 20)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	for _, adaptive := range []bool{false, true} {
+		name := "split"
+		if adaptive {
+			name = "adaptive"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				d := NewDownloader(DownloadOptions{DownloadDir: b.TempDir(), NumConcParts: 8, AdaptiveSplit: adaptive})
+				if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
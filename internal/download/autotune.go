@@ -0,0 +1,137 @@
+package download
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultAutoTuneMax caps the tuned concurrency when MaxLimitConcurrency
+	// is unset.
+	defaultAutoTuneMax = 16
+	// autoTuneChunksPerWorker is how many chunks per worker a tuned file is
+	// split into, so that throughput can be measured often enough.
+	autoTuneChunksPerWorker = 4
+	// autoTuneGain is the relative throughput improvement needed to keep
+	// increasing the concurrency.
+	autoTuneGain = 1.1
+)
+
+// tuner adjusts the number of parts of a file downloaded concurrently. It
+// starts with one part and doubles the concurrency as long as throughput
+// improves, once it plateaus the concurrency goes back to the last level
+// which improved and stays there.
+type tuner struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+	limit  int
+	max    int
+	peak   int
+	frozen bool
+
+	bytes     int64
+	lastBytes int64
+	lastCheck time.Time
+	lastRate  float64
+}
+
+func newTuner(max int) *tuner {
+	t := &tuner{limit: 1, max: max, lastCheck: time.Now()}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// autoTuneMax returns the highest concurrency the tuner may use.
+func (o DownloadOptions) autoTuneMax() int {
+	if o.MaxLimitConcurrency > 0 {
+		return o.MaxLimitConcurrency
+	}
+	return defaultAutoTuneMax
+}
+
+// autoTuneChunks returns how many chunks a tuned file of contentLength bytes
+// is split into.
+func (o DownloadOptions) autoTuneChunks(contentLength int64) int {
+	n := o.autoTuneMax() * autoTuneChunksPerWorker
+	if limit := contentLength / o.minPartSize(); int64(n) > limit {
+		n = int(limit)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// acquire blocks until another part may start or ctx is done.
+func (t *tuner) acquire(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.active >= t.limit && ctx.Err() == nil {
+		t.cond.Wait()
+	}
+	t.active++
+	if t.active > t.peak {
+		t.peak = t.active
+	}
+}
+
+// release marks a part as done and adjusts the concurrency based on the
+// throughput since the previous part finished.
+func (t *tuner) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	defer t.cond.Broadcast()
+
+	now := time.Now()
+	elapsed := now.Sub(t.lastCheck).Seconds()
+	if elapsed <= 0 || t.frozen {
+		return
+	}
+	total := atomic.LoadInt64(&t.bytes)
+	rate := float64(total-t.lastBytes) / elapsed
+	t.lastBytes, t.lastCheck = total, now
+
+	switch {
+	case t.lastRate == 0 || rate >= t.lastRate*autoTuneGain:
+		t.lastRate = rate
+		if t.limit < t.max {
+			t.limit *= 2
+			if t.limit > t.max {
+				t.limit = t.max
+			}
+		}
+	default:
+		if t.limit > 1 {
+			t.limit /= 2
+		}
+		t.frozen = true
+	}
+}
+
+// concurrency returns the highest number of parts which ran at once.
+func (t *tuner) concurrency() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.peak
+}
+
+// writer counts the bytes written to w towards the measured throughput.
+func (t *tuner) writer(w io.Writer) io.Writer {
+	return &tunerWriter{w: w, t: t}
+}
+
+type tunerWriter struct {
+	w io.Writer
+	t *tuner
+}
+
+func (tw *tunerWriter) Write(p []byte) (int, error) {
+	n, err := tw.w.Write(p)
+	atomic.AddInt64(&tw.t.bytes, int64(n))
+	return n, err
+}
@@ -0,0 +1,106 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTuner(t *testing.T) {
+	tu := newTuner(4)
+	// step finishes a part after rate bytes were written in the last second.
+	step := func(rate int64) int {
+		tu.acquire(context.Background())
+		tu.bytes += rate
+		tu.lastCheck = time.Now().Add(-time.Second)
+		tu.release()
+		return tu.limit
+	}
+	for i, tt := range []struct {
+		rate  int64
+		limit int
+	}{
+		{100, 2},
+		{300, 4},
+		// The maximum is reached, a plateau backs off and freezes.
+		{310, 2},
+		{1000, 2},
+		{10, 2},
+	} {
+		if got := step(tt.rate); got != tt.limit {
+			t.Errorf("step %d at %d bytes/s: limit = %d, want %d", i, tt.rate, got, tt.limit)
+		}
+	}
+}
+
+func TestAutoTuneLimit(t *testing.T) {
+	data := fixture(16 << 20)
+	var mu sync.Mutex
+	var active, peak int
+	// Every connection is limited to about 30MB/s, so more parts are
+	// faster.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+			return
+		}
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+		http.ServeContent(throttledWriter{w}, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, MaxLimitConcurrency: 3, AutoTune: true})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("downloaded file differs")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if peak > 3 || results[0].Concurrency > 3 {
+		t.Errorf("%d parts ran at once with a reported concurrency of %d, want at most MaxLimitConcurrency 3", peak, results[0].Concurrency)
+	}
+	if results[0].Concurrency < 2 {
+		t.Errorf("Concurrency = %d, want the tuner to add parts while throughput improves", results[0].Concurrency)
+	}
+}
+
+// throttledWriter writes at most 64KB every 2ms.
+type throttledWriter struct {
+	http.ResponseWriter
+}
+
+func (w throttledWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > 64<<10 {
+			chunk = chunk[:64<<10]
+		}
+		m, err := w.ResponseWriter.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+		time.Sleep(2 * time.Millisecond)
+	}
+	return n, nil
+}
@@ -0,0 +1,61 @@
+package download
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+// byteBudget holds the bytes files of unknown size may still write in one
+// Download call, see DownloadOptions.MaxTotalBytes.
+type byteBudget struct {
+	left int64
+}
+
+type byteBudgetKey struct{}
+
+// withByteBudget returns a context sharing a budget of n bytes between all
+// writers wrapped with budgetWriter.
+func withByteBudget(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, byteBudgetKey{}, &byteBudget{left: n})
+}
+
+// budgetWriter returns w charging every write to the byte budget of ctx,
+// or w itself when ctx has no budget.
+func budgetWriter(ctx context.Context, w io.Writer) io.Writer {
+	return prepaidBudgetWriter(ctx, w, 0)
+}
+
+// prepaidBudgetWriter is like budgetWriter for a file whose first prepaid
+// bytes were already counted against MaxTotalBytes before the batch
+// started, like the first page of a paged file.
+func prepaidBudgetWriter(ctx context.Context, w io.Writer, prepaid int64) io.Writer {
+	b, _ := ctx.Value(byteBudgetKey{}).(*byteBudget)
+	if b == nil {
+		return w
+	}
+	return &budgetedWriter{w: w, budget: b, prepaid: prepaid}
+}
+
+type budgetedWriter struct {
+	w      io.Writer
+	budget *byteBudget
+	// prepaid is how many of the next bytes are not charged.
+	prepaid int64
+}
+
+func (b *budgetedWriter) Write(p []byte) (int, error) {
+	charge := int64(len(p))
+	if b.prepaid > 0 {
+		free := b.prepaid
+		if free > charge {
+			free = charge
+		}
+		b.prepaid -= free
+		charge -= free
+	}
+	if charge > 0 && atomic.AddInt64(&b.budget.left, -charge) < 0 {
+		return 0, ErrTotalBytesExceeded
+	}
+	return b.w.Write(p)
+}
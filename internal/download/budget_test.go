@@ -0,0 +1,75 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxTotalBytesKnownSizes(t *testing.T) {
+	srv := serve(map[string][]byte{"/a.bin": fixture(600), "/b.bin": fixture(500)})
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), MaxTotalBytes: 1000})
+	_, err := d.DownloadAll(context.Background(), srv.URL+"/a.bin", srv.URL+"/b.bin")
+	if !errors.Is(err, ErrTotalBytesExceeded) {
+		t.Fatalf("DownloadAll() error = %v, want ErrTotalBytesExceeded", err)
+	}
+	if gets := len(srv.Ranges()); gets != 0 {
+		t.Errorf("%d GET requests were sent, want none", gets)
+	}
+
+	d = NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), MaxTotalBytes: 1100})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/a.bin", srv.URL+"/b.bin"); err != nil {
+		t.Fatalf("DownloadAll() within the limit: %v", err)
+	}
+}
+
+func TestMaxTotalBytesUnknownSize(t *testing.T) {
+	data := fixture(2000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Chunked, without a Content-Length.
+		if r.Method == http.MethodGet {
+			w.Write(data)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), MaxTotalBytes: 1000})
+	_, err := d.DownloadAll(context.Background(), srv.URL+"/stream.bin")
+	if !errors.Is(err, ErrTotalBytesExceeded) {
+		t.Fatalf("DownloadAll() error = %v, want ErrTotalBytesExceeded", err)
+	}
+
+	d = NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), MaxTotalBytes: 2000})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/stream.bin"); err != nil {
+		t.Fatalf("DownloadAll() within the limit: %v", err)
+	}
+}
+
+func TestMaxTotalBytesPages(t *testing.T) {
+	page := fixture(600)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/two") && r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", `<?page=2>; rel="next"`)
+		}
+		http.ServeContent(w, r, "page", fixedModTime, bytes.NewReader(page))
+	}))
+	defer srv.Close()
+
+	// The HEAD size of the first page is counted once, not again while
+	// it is downloaded.
+	d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), FollowNextLinks: true, MaxTotalBytes: 1000})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/one.bin"); err != nil {
+		t.Fatalf("DownloadAll() of one page within the limit: %v", err)
+	}
+	_, err := d.DownloadAll(context.Background(), srv.URL+"/two.bin")
+	if !errors.Is(err, ErrTotalBytesExceeded) {
+		t.Fatalf("DownloadAll() of two pages error = %v, want ErrTotalBytesExceeded", err)
+	}
+}
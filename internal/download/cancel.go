@@ -0,0 +1,89 @@
+package download
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// CancelURL stops the downloads of url, running or still waiting for a
+// slot, without aborting the rest of their batch. Their results report
+// ErrCancelled. It returns false when no download of url is in progress.
+func (d *Downloader) CancelURL(url string) bool {
+	return d.cancels.cancel(url)
+}
+
+// cancelSet holds the cancel funcs of the files being downloaded, by url.
+type cancelSet struct {
+	mu    sync.Mutex
+	files map[string][]*fileCancel
+}
+
+// fileCancel cancels the context of a single file.
+type fileCancel struct {
+	cancelFunc context.CancelFunc
+	// done is set to 1 once cancelled by CancelURL.
+	done int32
+}
+
+// cancelled reports whether the file was cancelled by CancelURL.
+func (f *fileCancel) cancelled() bool {
+	return atomic.LoadInt32(&f.done) == 1
+}
+
+// add returns a context for a file of url which is cancelled by CancelURL.
+// remove must be called once the file is finished, it may be called more
+// than once.
+func (s *cancelSet) add(ctx context.Context, url string) (context.Context, *fileCancel) {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &fileCancel{cancelFunc: cancel}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string][]*fileCancel)
+	}
+	s.files[url] = append(s.files[url], f)
+	return ctx, f
+}
+
+// bind moves f to a new context derived from ctx, which is cancelled right
+// away when f already was.
+func (s *cancelSet) bind(ctx context.Context, f *fileCancel) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.cancelFunc()
+	f.cancelFunc = cancel
+	if f.cancelled() {
+		cancel()
+	}
+	return ctx
+}
+
+func (s *cancelSet) remove(url string, f *fileCancel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.cancelFunc()
+	files := s.files[url]
+	for i, other := range files {
+		if other == f {
+			files = append(files[:i], files[i+1:]...)
+			break
+		}
+	}
+	if len(files) == 0 {
+		delete(s.files, url)
+	} else {
+		s.files[url] = files
+	}
+}
+
+func (s *cancelSet) cancel(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files[url] {
+		atomic.StoreInt32(&f.done, 1)
+		f.cancelFunc()
+	}
+	return len(s.files[url]) > 0
+}
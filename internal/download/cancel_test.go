@@ -0,0 +1,115 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// trickleServer serves /slow.bin, which announces 1MB but sends 1000 bytes
+// every 10ms, and every other path at once. started is closed by the first
+// GET of slow.bin, gets counts the GETs by path.
+type trickleServer struct {
+	*httptest.Server
+	started chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	gets    map[string]int
+}
+
+func newTrickleServer() *trickleServer {
+	s := &trickleServer{started: make(chan struct{}), gets: make(map[string]int)}
+	files := serve(map[string][]byte{"/a.bin": fixture(1000), "/b.bin": fixture(2000)})
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			s.mu.Lock()
+			s.gets[r.URL.Path]++
+			s.mu.Unlock()
+		}
+		if r.URL.Path != "/slow.bin" {
+			files.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", "1000000")
+		if r.Method == http.MethodHead {
+			return
+		}
+		s.once.Do(func() { close(s.started) })
+		for i := 0; i < 1000; i++ {
+			if _, err := w.Write(make([]byte, 1000)); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	return s
+}
+
+func TestCancelURL(t *testing.T) {
+	srv := newTrickleServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir})
+	if d.CancelURL(srv.URL + "/slow.bin") {
+		t.Error("CancelURL() before the download = true")
+	}
+	go func() {
+		<-srv.started
+		if !d.CancelURL(srv.URL + "/slow.bin") {
+			t.Error("CancelURL() of the running download = false")
+		}
+	}()
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/slow.bin", srv.URL+"/a.bin", srv.URL+"/b.bin")
+	if err != nil {
+		t.Fatalf("DownloadAll() error = %v, a cancelled file is no failure", err)
+	}
+	if !errors.Is(results[0].Err, ErrCancelled) {
+		t.Errorf("result of slow.bin = %+v, want ErrCancelled", results[0])
+	}
+	for _, r := range results[1:] {
+		if r.Err != nil || r.Status != StatusDownloaded {
+			t.Errorf("result of %s = %+v, want it downloaded", r.URL, r)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "slow.bin")); !os.IsNotExist(err) {
+		t.Errorf("the cancelled download left slow.bin behind: %v", err)
+	}
+	if d.CancelURL(srv.URL + "/slow.bin") {
+		t.Error("CancelURL() after the download = true")
+	}
+}
+
+func TestCancelURLWaiting(t *testing.T) {
+	srv := newTrickleServer()
+	defer srv.Close()
+
+	// a.bin waits for the only slot while slow.bin downloads.
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage(), MaxLimitConcurrency: 1})
+	go func() {
+		<-srv.started
+		d.CancelURL(srv.URL + "/a.bin")
+		d.CancelURL(srv.URL + "/slow.bin")
+	}()
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/slow.bin", srv.URL+"/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if !errors.Is(r.Err, ErrCancelled) {
+			t.Errorf("result of %s = %+v, want ErrCancelled", r.URL, r)
+		}
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if n := srv.gets["/a.bin"]; n != 0 {
+		t.Errorf("the waiting a.bin was requested %d times after it was cancelled", n)
+	}
+}
@@ -0,0 +1,135 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// defaultMaxRedirects matches the limit of the net/http default client.
+const defaultMaxRedirects = 10
+
+// newHTTPClient returns the client shared by all requests of a Downloader.
+// It is based on http.DefaultTransport, so HTTP/2 is negotiated when the
+// server supports it and the parts of a file multiplex over one connection.
+func newHTTPClient(opts DownloadOptions) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	if transport.MaxIdleConnsPerHost <= 0 {
+		transport.MaxIdleConnsPerHost = opts.NumConcParts
+		if transport.MaxIdleConnsPerHost < http.DefaultMaxIdleConnsPerHost {
+			transport.MaxIdleConnsPerHost = http.DefaultMaxIdleConnsPerHost
+		}
+	}
+	transport.DisableKeepAlives = opts.DisableKeepAlives
+	if opts.DialContext != nil || opts.Resolver != nil || opts.DNSCacheTTL > 0 {
+		transport.DialContext = newDialer(opts)
+	}
+	if opts.Proxy != "" {
+		setProxy(transport, opts)
+	}
+	if opts.TLSConfig != nil {
+		transport.TLSClientConfig = opts.TLSConfig.Clone()
+	}
+	jar := opts.CookieJar
+	if jar == nil {
+		// cookiejar.New only fails for a broken PublicSuffixList.
+		jar, _ = cookiejar.New(nil)
+	}
+	client := &http.Client{Transport: transport, Jar: jar}
+	if opts.MaxConnsPerHost > 0 {
+		client.Transport = newHostLimiter(transport, opts.MaxConnsPerHost)
+	}
+	max := opts.MaxRedirects
+	switch {
+	case opts.NoRedirects || max < 0:
+		max = 0
+	case max == 0:
+		max = defaultMaxRedirects
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return fmt.Errorf("%w: stopped after %d redirects", ErrTooManyRedirects, max)
+		}
+		return nil
+	}
+	return client
+}
+
+// setProxy sends the requests of transport through the proxy url of opts.
+// An http or https proxy becomes the Proxy of transport. For socks5 and
+// socks5h the connections are dialed by golang.org/x/net/proxy on top of
+// the dialer of opts, authenticating with the user info of the url; both
+// let the proxy resolve the host names. An invalid url fails every request
+// instead of silently bypassing the proxy.
+func setProxy(transport *http.Transport, opts DownloadOptions) {
+	u, err := parseProxyURL(opts.Proxy)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		transport.Proxy = http.ProxyURL(u)
+		return
+	}
+	if err == nil {
+		var dialer proxy.Dialer
+		dialer, err = proxy.SOCKS5("tcp", u.Host, proxyAuth(u), newDialer(opts))
+		if err == nil {
+			transport.Proxy = nil
+			transport.DialContext = dialer.(proxy.ContextDialer).DialContext
+			return
+		}
+		err = fmt.Errorf("error while creating the SOCKS5 dialer for %s: %v", u.Redacted(), err)
+	}
+	transport.Proxy = func(*http.Request) (*url.URL, error) {
+		return nil, err
+	}
+}
+
+// parseProxyURL parses the proxy url raw. Its errors never contain the
+// password of raw.
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// Both the *url.Error and its cause may quote parts of raw.
+		if redacted := redactUserinfo(raw); redacted != raw {
+			return nil, fmt.Errorf("error while parsing proxy url %s: invalid url", redacted)
+		}
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("error while parsing proxy url %s: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("error while parsing proxy url %s: unsupported proxy scheme %q", u.Redacted(), u.Scheme)
+}
+
+// redactUserinfo replaces everything between the scheme and the last "@"
+// of raw, which may not parse as a url, with "xxxxx".
+func redactUserinfo(raw string) string {
+	rest := raw
+	if i := strings.Index(raw, "://"); i >= 0 {
+		rest = raw[i+3:]
+	}
+	at := strings.LastIndex(rest, "@")
+	if at < 0 {
+		return raw
+	}
+	return raw[:len(raw)-len(rest)] + "xxxxx" + rest[at:]
+}
+
+// proxyAuth returns the SOCKS5 credentials of the user info of u, nil when
+// it has none.
+func proxyAuth(u *url.URL) *proxy.Auth {
+	if u.User == nil {
+		return nil
+	}
+	password, _ := u.User.Password()
+	return &proxy.Auth{User: u.User.Username(), Password: password}
+}
@@ -0,0 +1,305 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShortBodyDetected(t *testing.T) {
+	// The body announces more bytes than it has.
+	lying := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5000")
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(make([]byte, 100))
+	}))
+	defer lying.Close()
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage()})
+	if _, err := d.DownloadAll(context.Background(), lying.URL+"/f.bin"); err == nil {
+		t.Error("DownloadAll() of a truncated body succeeded")
+	}
+
+	// The body is shorter than the HEAD response announced.
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "5000")
+			return
+		}
+		w.Write(make([]byte, 100))
+	}))
+	defer short.Close()
+	d = NewDownloader(DownloadOptions{Storage: newMemStorage(), StrictSize: true})
+	if _, err := d.DownloadAll(context.Background(), short.URL+"/f.bin"); !errors.Is(err, ErrShortWrite) {
+		t.Errorf("DownloadAll() error = %v, want ErrShortWrite", err)
+	}
+}
+
+func TestShortPartDetected(t *testing.T) {
+	data := fixture(12 << 20)
+	// The last part ends 10 bytes early, with a consistent Content-Length.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil && end == len(data)-1 {
+			end -= 10
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start : end+1])
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	for _, storage := range []Storage{LocalStorage{Dir: t.TempDir()}, newMemStorage()} {
+		d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 3})
+		_, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+		if !errors.Is(err, ErrShortWrite) {
+			t.Errorf("DownloadAll() with %T error = %v, want ErrShortWrite", storage, err)
+		}
+	}
+}
+
+// cancellingStorage cancels once more than after bytes were written to its
+// files.
+type cancellingStorage struct {
+	*memStorage
+	after  int64
+	cancel func()
+}
+
+func (s cancellingStorage) Create(name string) (io.WriteCloser, error) {
+	w, err := s.memStorage.Create(name)
+	return &cancellingWriter{WriteCloser: w, s: s}, err
+}
+
+type cancellingWriter struct {
+	io.WriteCloser
+	s       cancellingStorage
+	written int64
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if w.written > w.s.after {
+		w.s.cancel()
+	}
+	return w.WriteCloser.Write(p)
+}
+
+func TestCancelDuringCombine(t *testing.T) {
+	srv := serve(map[string][]byte{"/f.bin": fixture(12 << 20)})
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The parts are combined into the output file once all of them are
+	// downloaded, the combine is cancelled halfway.
+	storage := cancellingStorage{memStorage: newMemStorage(), after: 6 << 20, cancel: cancel}
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 4})
+	_, err := d.DownloadContext(ctx, srv.URL+"/f.bin")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DownloadContext() error = %v, want context.Canceled", err)
+	}
+	if n := len(storage.files); n != 0 {
+		t.Errorf("the cancelled combine left %d files in the storage", n)
+	}
+}
+
+func TestCombineChunksOrder(t *testing.T) {
+	dir := t.TempDir()
+	chunks := make([]*os.File, 3)
+	// The parts finish out of order, combine follows their order in the
+	// slice, not the order they were written in.
+	for _, i := range []int{2, 0, 1} {
+		f, err := os.Create(filepath.Join(dir, strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		fmt.Fprintf(f, "part%d,", i)
+		chunks[i] = f
+	}
+	sum := func(s string) []byte {
+		h := sha256.Sum256([]byte(s))
+		return h[:]
+	}
+	var out bytes.Buffer
+	sums := [][]byte{nil, sum("part1,"), nil}
+	n, err := combineChunks(context.Background(), chunks, sums, &out, make([]byte, 4))
+	if err != nil || n != 18 || out.String() != "part0,part1,part2," {
+		t.Errorf("combineChunks() = %d, %v, %q, want the parts in order", n, err, out.String())
+	}
+
+	// A part changed after its sum was taken.
+	out.Reset()
+	sums[1] = sum("other")
+	if _, err := combineChunks(context.Background(), chunks, sums, &out, make([]byte, 4)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("combineChunks() of a changed part error = %v, want ErrChecksumMismatch", err)
+	}
+	if out.String() != "part0,part1," {
+		t.Errorf("combineChunks() wrote %q, want it to stop after the changed part", out.String())
+	}
+}
+
+func TestCombineAfterPartCreateFails(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	// Part 1 of 3 can't be staged, the loop creating the parts fails midway.
+	tmp := t.TempDir()
+	header := http.Header{"Last-Modified": {fixedModTime.UTC().Format(http.TimeFormat)}}
+	blocked := stagedPartPath(tmp, "f.bin", srv.URL+"/f.bin", header, int64(len(data)), 3, 1)
+	if err := os.Mkdir(blocked, 0700); err != nil {
+		t.Fatal(err)
+	}
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: tmp, Resume: true, NumConcParts: 3})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err == nil || !strings.Contains(err.Error(), "temporary file") {
+		t.Fatalf("DownloadAll() error = %v, want the failed part file", err)
+	}
+	if storage.file("f.bin") != nil {
+		t.Error("the parts created before the failure were combined")
+	}
+
+	// Once the part can be staged the rerun combines all of them.
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("downloaded file differs")
+	}
+}
+
+func TestStrictSize(t *testing.T) {
+	// HEAD announces 100 bytes, the GET delivers a consistent 50.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "100")
+			return
+		}
+		w.Header().Set("Content-Length", "50")
+		w.Write(fixture(50))
+	}))
+	defer srv.Close()
+
+	for _, strict := range []bool{false, true} {
+		for _, local := range []bool{true, false} {
+			dir := t.TempDir()
+			var storage Storage = LocalStorage{Dir: dir}
+			if !local {
+				storage = newMemStorage()
+			}
+			d := NewDownloader(DownloadOptions{Storage: storage, StrictSize: strict})
+			results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+			if strict {
+				if !errors.Is(err, ErrShortWrite) {
+					t.Errorf("DownloadAll() to %T with StrictSize error = %v, want ErrShortWrite", storage, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The bytes received are trusted over the HEAD response.
+			got, _ := os.ReadFile(filepath.Join(dir, "f.bin"))
+			if !local {
+				got = storage.(*memStorage).file("f.bin")
+			}
+			if results[0].Size != 50 || !bytes.Equal(got, fixture(50)) {
+				t.Errorf("DownloadAll() to %T stored %d bytes of size %d, want the 50 bytes received", storage, len(got), results[0].Size)
+			}
+		}
+	}
+}
+
+// combineCounter is a memStorage recording how many of its files are
+// written at once. Parts of a memStorage are staged, so its files are only
+// written while they are combined.
+type combineCounter struct {
+	*memStorage
+	mu           sync.Mutex
+	active, peak int
+}
+
+func (c *combineCounter) Create(name string) (io.WriteCloser, error) {
+	w, err := c.memStorage.Create(name)
+	return nopCloser{&combineCounterWriter{c: c, w: w}}, err
+}
+
+type combineCounterWriter struct {
+	c *combineCounter
+	w io.Writer
+}
+
+func (w *combineCounterWriter) Write(p []byte) (int, error) {
+	w.c.mu.Lock()
+	w.c.active++
+	if w.c.active > w.c.peak {
+		w.c.peak = w.c.active
+	}
+	w.c.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	w.c.mu.Lock()
+	w.c.active--
+	w.c.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func TestMaxConcurrentCombines(t *testing.T) {
+	files := make(map[string][]byte)
+	var urls []string
+	for i := 0; i < 5; i++ {
+		files[fmt.Sprintf("/f%d.bin", i)] = fixture(12<<20 + i)
+	}
+	srv := serve(files)
+	defer srv.Close()
+	for i := 0; i < 5; i++ {
+		urls = append(urls, fmt.Sprintf("%s/f%d.bin", srv.URL, i))
+	}
+
+	tests := []struct {
+		max     int
+		wantMax int
+	}{
+		{1, 1},
+		{0, defaultConcurrentCombines},
+		{5, 5},
+	}
+	for _, tt := range tests {
+		storage := &combineCounter{memStorage: newMemStorage()}
+		d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 4, MaxConcurrentCombines: tt.max})
+		if _, err := d.Download(urls...); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			if !bytes.Equal(storage.file(fmt.Sprintf("f%d.bin", i)), files[fmt.Sprintf("/f%d.bin", i)]) {
+				t.Errorf("f%d.bin differs", i)
+			}
+		}
+		if storage.peak > tt.wantMax {
+			t.Errorf("%d files combined at once with MaxConcurrentCombines %d, want at most %d", storage.peak, tt.max, tt.wantMax)
+		}
+		// The files finish together, without the limit they combine at once.
+		if tt.max == 5 && storage.peak < 2 {
+			t.Errorf("%d files combined at once without a limit, want them to overlap", storage.peak)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package download
+
+import "io"
+
+// defaultCopyBufferSize is used when DownloadOptions.CopyBufferSize is unset.
+const defaultCopyBufferSize = 256 * 1024
+
+// newCopyBuffer allocates a buffer of CopyBufferSize bytes for copying
+// response bodies and parts. It is the New of the buffer pool of the
+// Downloader.
+func (d *Downloader) newCopyBuffer() interface{} {
+	size := d.downloadOptions.CopyBufferSize
+	if size <= 0 {
+		size = defaultCopyBufferSize
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+// getBuffer takes a copy buffer from the pool, it must be given back with
+// putBuffer.
+func (d *Downloader) getBuffer() *[]byte {
+	return d.buffers.Get().(*[]byte)
+}
+
+func (d *Downloader) putBuffer(buf *[]byte) {
+	d.buffers.Put(buf)
+}
+
+// copyWithBuffer copies src to dst like io.Copy, but through a pooled
+// buffer of CopyBufferSize bytes. With ReadAhead the reads and writes
+// overlap, see readAheadCopy.
+func (d *Downloader) copyWithBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	if n := d.downloadOptions.ReadAhead; n > 0 {
+		return d.readAheadCopy(dst, src, n)
+	}
+	buf := d.getBuffer()
+	defer d.putBuffer(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// chunk is a pooled buffer holding n bytes read by readAheadCopy.
+type chunk struct {
+	buf *[]byte
+	n   int
+}
+
+// readAheadCopy copies src to dst, writing in a separate goroutine so src
+// is read while the previous bytes are written. Reads block once n pooled
+// buffers wait for their write, so a slow dst holds back src instead of
+// growing the memory used. It returns once all buffers were written.
+func (d *Downloader) readAheadCopy(dst io.Writer, src io.Reader, n int) (int64, error) {
+	chunks := make(chan chunk, n)
+	// failed is closed when a write failed, werr and written are only read
+	// after done is closed.
+	failed := make(chan struct{})
+	done := make(chan struct{})
+	var written int64
+	var werr error
+	go func() {
+		defer close(done)
+		for c := range chunks {
+			if werr == nil {
+				m, err := dst.Write((*c.buf)[:c.n])
+				written += int64(m)
+				if err == nil && m < c.n {
+					err = io.ErrShortWrite
+				}
+				if err != nil {
+					werr = err
+					close(failed)
+				}
+			}
+			d.putBuffer(c.buf)
+		}
+	}()
+
+	var rerr error
+read:
+	for {
+		buf := d.getBuffer()
+		m, err := src.Read(*buf)
+		if m > 0 {
+			select {
+			case chunks <- chunk{buf: buf, n: m}:
+			case <-failed:
+				d.putBuffer(buf)
+				break read
+			}
+		} else {
+			d.putBuffer(buf)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rerr = err
+			break
+		}
+	}
+	close(chunks)
+	<-done
+	if werr != nil {
+		return written, werr
+	}
+	return written, rerr
+}
@@ -0,0 +1,245 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// onlyReader and onlyWriter hide ReaderFrom and WriterTo so copies go
+// through the buffer.
+type onlyReader struct{ io.Reader }
+
+type onlyWriter struct{ io.Writer }
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestTinyCopyBuffer(t *testing.T) {
+	data := fixture(11<<20 + 5)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	// Local files are written in place, the parts for memStorage are staged
+	// and combined through the buffer too.
+	dir := t.TempDir()
+	storage := newMemStorage()
+	for _, opts := range []DownloadOptions{{DownloadDir: dir}, {Storage: storage, TempDir: t.TempDir()}} {
+		opts.NumConcParts = 3
+		opts.CopyBufferSize = 7
+		results, err := NewDownloader(opts).DownloadAll(context.Background(), srv.URL+"/f.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0].Size != int64(len(data)) {
+			t.Errorf("Size = %d, want %d", results[0].Size, len(data))
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "f.bin")); !bytes.Equal(b, data) {
+		t.Error("local file differs")
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("stored file differs")
+	}
+}
+
+func BenchmarkCopyBufferSize(b *testing.B) {
+	data := fixture(32 << 20)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	for _, size := range []int{4 << 10, 32 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				d := NewDownloader(DownloadOptions{Storage: newMemStorage(), TempDir: b.TempDir(), NumConcParts: 4, CopyBufferSize: size})
+				if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyBufferPoolConcurrent(t *testing.T) {
+	for _, readAhead := range []int{0, 2} {
+		d := NewDownloader(DownloadOptions{CopyBufferSize: 1000, ReadAhead: readAhead})
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				data := fixture(100<<10 + i)
+				var got bytes.Buffer
+				if _, err := d.copyWithBuffer(onlyWriter{&got}, onlyReader{bytes.NewReader(data)}); err != nil {
+					t.Error(err)
+				}
+				if !bytes.Equal(got.Bytes(), data) {
+					t.Errorf("copy %d with ReadAhead %d differs", i, readAhead)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
+func TestCopyBufferReturnedOnError(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector drops pooled buffers")
+	}
+	for _, readAhead := range []int{0, 2} {
+		d := NewDownloader(DownloadOptions{CopyBufferSize: 1000, ReadAhead: readAhead})
+		var allocs int32
+		d.buffers.New = func() interface{} {
+			atomic.AddInt32(&allocs, 1)
+			return d.newCopyBuffer()
+		}
+		for i := 0; i < 100; i++ {
+			if _, err := d.copyWithBuffer(failingWriter{}, onlyReader{bytes.NewReader(fixture(10000))}); err == nil {
+				t.Fatal("copyWithBuffer() to a failing writer succeeded")
+			}
+		}
+		// Buffers leaked on the error path would need 100 allocations.
+		if n := atomic.LoadInt32(&allocs); n > 10 {
+			t.Errorf("100 failed copies with ReadAhead %d allocated %d buffers", readAhead, n)
+		}
+	}
+}
+
+// BenchmarkCopyBufferPool compares the allocations of pooled buffers to a
+// fresh buffer per copy, the way one per part was allocated before.
+func BenchmarkCopyBufferPool(b *testing.B) {
+	data := fixture(1 << 20)
+	d := NewDownloader(DownloadOptions{})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			d.copyWithBuffer(onlyWriter{io.Discard}, onlyReader{bytes.NewReader(data)})
+		}
+	})
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			io.CopyBuffer(onlyWriter{io.Discard}, onlyReader{bytes.NewReader(data)}, make([]byte, defaultCopyBufferSize))
+		}
+	})
+}
+
+// blockedWriter blocks every write until release is closed.
+type blockedWriter struct {
+	release chan struct{}
+	buf     bytes.Buffer
+}
+
+func (w *blockedWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.buf.Write(p)
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r    io.Reader
+	read *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+func TestReadAheadBounded(t *testing.T) {
+	const bufSize, readAhead = 4096, 3
+	data := fixture(1 << 20)
+	d := NewDownloader(DownloadOptions{CopyBufferSize: bufSize, ReadAhead: readAhead})
+	w := &blockedWriter{release: make(chan struct{})}
+	var read int64
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.copyWithBuffer(w, countingReader{r: bytes.NewReader(data), read: &read})
+		done <- err
+	}()
+
+	// With the writer stuck the reads stop once the queue is full: one
+	// buffer in the write, readAhead queued and one waiting to be queued.
+	var last int64 = -1
+	for n := atomic.LoadInt64(&read); n != last; n = atomic.LoadInt64(&read) {
+		last = n
+		time.Sleep(50 * time.Millisecond)
+	}
+	if max := int64(readAhead+2) * bufSize; last > max {
+		t.Errorf("read %d bytes ahead of a stuck writer, want at most %d", last, max)
+	}
+	close(w.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.buf.Bytes(), data) {
+		t.Error("copied bytes differ")
+	}
+}
+
+func TestReadAheadDownload(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	storage := newMemStorage()
+	for _, opts := range []DownloadOptions{{DownloadDir: dir}, {Storage: storage, TempDir: t.TempDir()}} {
+		opts.NumConcParts, opts.ReadAhead = 3, 2
+		if _, err := NewDownloader(opts).DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "f.bin")); !bytes.Equal(b, data) {
+		t.Error("local file differs")
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("stored file differs")
+	}
+}
+
+// slowReadWriter sleeps for every read or write, like a network and a disk
+// of similar speed.
+type slowReadWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func (s slowReadWriter) Read(p []byte) (int, error) {
+	time.Sleep(100 * time.Microsecond)
+	return s.Reader.Read(p)
+}
+
+func (s slowReadWriter) Write(p []byte) (int, error) {
+	time.Sleep(100 * time.Microsecond)
+	return s.Writer.Write(p)
+}
+
+// BenchmarkReadAhead shows reads and writes overlapping with ReadAhead.
+func BenchmarkReadAhead(b *testing.B) {
+	data := fixture(1 << 20)
+	for _, readAhead := range []int{0, 4} {
+		b.Run(fmt.Sprintf("ReadAhead%d", readAhead), func(b *testing.B) {
+			d := NewDownloader(DownloadOptions{CopyBufferSize: 16 << 10, ReadAhead: readAhead})
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				d.copyWithBuffer(slowReadWriter{Writer: io.Discard}, slowReadWriter{Reader: bytes.NewReader(data)})
+			}
+		})
+	}
+}
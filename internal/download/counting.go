@@ -0,0 +1,54 @@
+package download
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// CountingReader counts the bytes read from the reader it wraps. Count is
+// safe to call while another goroutine reads, e.g. to show progress.
+type CountingReader struct {
+	// n is first to be 64-bit aligned for the atomic operations.
+	n int64
+	r io.Reader
+}
+
+// NewCountingReader returns a CountingReader reading from r.
+func NewCountingReader(r io.Reader) *CountingReader {
+	return &CountingReader{r: r}
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes read so far.
+func (c *CountingReader) Count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// CountingWriter counts the bytes written to the writer it wraps. Count is
+// safe to call while another goroutine writes.
+type CountingWriter struct {
+	// n is first to be 64-bit aligned for the atomic operations.
+	n int64
+	w io.Writer
+}
+
+// NewCountingWriter returns a CountingWriter writing to w.
+func NewCountingWriter(w io.Writer) *CountingWriter {
+	return &CountingWriter{w: w}
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes written so far.
+func (c *CountingWriter) Count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
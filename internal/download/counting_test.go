@@ -0,0 +1,106 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// zeroReader fills every read and is safe for concurrent reads.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// shortReader returns n bytes and err on every read.
+type shortReader struct {
+	n   int
+	err error
+}
+
+func (r shortReader) Read(p []byte) (int, error) {
+	return r.n, r.err
+}
+
+func TestCountingReaderConcurrent(t *testing.T) {
+	c := NewCountingReader(zeroReader{})
+	const readers, reads, size = 8, 1000, 100
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, size)
+			for j := 0; j < reads; j++ {
+				c.Read(buf)
+			}
+		}()
+	}
+	// Count is read while the others read.
+	var last int64
+	for i := 0; i < 100; i++ {
+		n := c.Count()
+		if n < last {
+			t.Fatalf("Count() went back from %d to %d", last, n)
+		}
+		last = n
+	}
+	wg.Wait()
+	if got := c.Count(); got != readers*reads*size {
+		t.Errorf("Count() = %d, want %d", got, readers*reads*size)
+	}
+}
+
+func TestCountingReader(t *testing.T) {
+	data := fixture(1 << 20)
+	c := NewCountingReader(bytes.NewReader(data))
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Count() != int64(len(data)) || !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("Count() = %d after reading %d bytes", c.Count(), got.Len())
+	}
+
+	// The bytes of a failing read count, its error is passed on.
+	broken := errors.New("connection reset")
+	c = NewCountingReader(shortReader{n: 5, err: broken})
+	if n, err := c.Read(make([]byte, 10)); n != 5 || err != broken || c.Count() != 5 {
+		t.Errorf("Read() = %d, %v, Count() = %d, want 5 bytes and the error", n, err, c.Count())
+	}
+}
+
+func TestCountingWriter(t *testing.T) {
+	c := NewCountingWriter(io.Discard)
+	const writers, writes, size = 8, 1000, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, size)
+			for j := 0; j < writes; j++ {
+				c.Write(buf)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		c.Count()
+	}
+	wg.Wait()
+	if got := c.Count(); got != writers*writes*size {
+		t.Errorf("Count() = %d, want %d", got, writers*writes*size)
+	}
+
+	// Only the bytes written count.
+	c = NewCountingWriter(failingWriter{})
+	if _, err := c.Write(make([]byte, 10)); err == nil || c.Count() != 0 {
+		t.Errorf("Write() to a failing writer = %v, Count() = %d, want an error and 0", err, c.Count())
+	}
+}
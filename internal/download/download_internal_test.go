@@ -0,0 +1,606 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOffsetWritersConcurrent(t *testing.T) {
+	data := fixture(1 << 20)
+	f, err := os.Create(filepath.Join(t.TempDir(), "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each writer writes its part in small chunks, interleaving with the
+	// others.
+	const parts = 8
+	partSize := len(data) / parts
+	var wg sync.WaitGroup
+	for i := 0; i < parts; i++ {
+		start := i * partSize
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := &offsetWriter{w: f, start: int64(start), off: int64(start)}
+			for off := start; off < start+partSize; off += 1000 {
+				end := off + 1000
+				if end > start+partSize {
+					end = start + partSize
+				}
+				if _, err := w.Write(data[off:end]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			if w.written() != int64(partSize) {
+				t.Errorf("written() = %d, want %d", w.written(), partSize)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("file written at disjoint offsets differs")
+	}
+}
+
+func TestSequentialWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &sequentialWriter{w: &buf}
+	if _, err := w.WriteAt([]byte("abc"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteAt([]byte("def"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteAt([]byte("x"), 2); err == nil {
+		t.Error("WriteAt() of an earlier offset succeeded")
+	}
+	if buf.String() != "abcdef" {
+		t.Errorf("got %q, want %q", buf.String(), "abcdef")
+	}
+}
+
+func TestMultiPartWritesInPlace(t *testing.T) {
+	data := fixture(12<<20 + 3)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 5})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(results[0].Path)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file has %d bytes and %v, want the served file", len(got), err)
+	}
+	// The parts are written into the file, nothing is staged next to it.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("download dir has %v, want only f.bin", names)
+	}
+}
+
+func TestFailedFilesReleaseSlots(t *testing.T) {
+	srv := serve(map[string][]byte{"/a.bin": fixture(10), "/b.bin": fixture(20), "/c.bin": fixture(12 << 20)})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	// a.bin fails when its output file is created, after the slot was taken.
+	if err := os.WriteFile(filepath.Join(dir, "a.bin"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 2, MaxLimitConcurrency: 1})
+	done := make(chan []DownloadResult, 1)
+	go func() {
+		results, _ := d.DownloadAll(context.Background(), srv.URL+"/a.bin", srv.URL+"/missing.bin", srv.URL+"/b.bin", srv.URL+"/c.bin")
+		done <- results
+	}()
+	var results []DownloadResult
+	select {
+	case results = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("DownloadAll() hangs, a failed file leaked its slot")
+	}
+	if !errors.Is(results[0].Err, ErrFileExists) || results[1].Err == nil {
+		t.Errorf("errors = %v, %v, want a.bin and missing.bin failed", results[0].Err, results[1].Err)
+	}
+	if results[2].Err != nil || results[3].Err != nil {
+		t.Errorf("errors = %v, %v, want b.bin and c.bin downloaded after the failures", results[2].Err, results[3].Err)
+	}
+}
+
+// createCounter counts the files created in a memStorage.
+type createCounter struct {
+	*memStorage
+	creates int32
+}
+
+func (c *createCounter) Create(name string) (io.WriteCloser, error) {
+	atomic.AddInt32(&c.creates, 1)
+	return c.memStorage.Create(name)
+}
+
+func TestSmallFileFastPath(t *testing.T) {
+	data := fixture(100 << 10)
+	tempDir := t.TempDir()
+	var staged []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			staged, _ = filepath.Glob(filepath.Join(tempDir, "*"))
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	storage := &createCounter{memStorage: newMemStorage()}
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: tempDir, NumConcParts: 4})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The body is streamed into the output file, no part is staged.
+	if len(staged) != 0 || storage.creates != 1 {
+		t.Errorf("staged %q and created %d files, want only the output file", staged, storage.creates)
+	}
+	if results[0].Concurrency != 1 || !bytes.Equal(storage.file("f.bin"), data) {
+		t.Errorf("Concurrency = %d, want the file in a single stream", results[0].Concurrency)
+	}
+}
+
+func TestCheckContentType(t *testing.T) {
+	allowed := []string{"application/zip", "IMAGE/*"}
+	tests := []struct {
+		contentType string
+		ok          bool
+	}{
+		{"application/zip", true},
+		{"Application/ZIP", true},
+		{"image/png", true},
+		{"image/svg+xml; charset=utf-8", true},
+		{"text/html; charset=utf-8", false},
+		{"application/zip-compressed", false},
+		{"imagex/png", false},
+		{"", false},
+		{"not a type", false},
+	}
+	for _, tt := range tests {
+		header := http.Header{"Content-Type": {tt.contentType}}
+		if err := checkContentType(allowed, header); (err == nil) != tt.ok || err != nil && !errors.Is(err, ErrContentType) {
+			t.Errorf("checkContentType(%q) = %v, want ok: %v", tt.contentType, err, tt.ok)
+		}
+	}
+	if err := checkContentType(nil, http.Header{}); err != nil {
+		t.Errorf("checkContentType() without allowed types = %v", err)
+	}
+}
+
+func TestAllowedContentTypes(t *testing.T) {
+	// A captive portal answers every url with a login page.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/a.png" {
+			w.Header().Set("Content-Type", "image/png")
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Write([]byte("<html>login</html>"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, AllowedContentTypes: []string{"application/zip", "image/*"}})
+	results, _ := d.DownloadAll(context.Background(), srv.URL+"/a.zip", srv.URL+"/a.png")
+	if !errors.Is(results[0].Err, ErrContentType) {
+		t.Errorf("a.zip error = %v, want ErrContentType", results[0].Err)
+	}
+	if results[1].Err != nil {
+		t.Errorf("a.png error = %v", results[1].Err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.zip")); !os.IsNotExist(err) {
+		t.Errorf("the rejected a.zip was written: %v", err)
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+	var gets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	storage := newMemStorage()
+	for _, opts := range []DownloadOptions{{DownloadDir: dir, NumConcParts: 4}, {Storage: storage, NumConcParts: 4}} {
+		results, err := NewDownloader(opts).DownloadAll(context.Background(), srv.URL+"/empty.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0].Size != 0 || results[0].Status != StatusDownloaded {
+			t.Errorf("result = %+v, want an empty downloaded file", results[0])
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "empty.bin")); err != nil || fi.Size() != 0 {
+		t.Errorf("local file: %v, want an empty file", err)
+	}
+	if !storage.Exists("empty.bin") || len(storage.file("empty.bin")) != 0 {
+		t.Error("storage has no empty file")
+	}
+	// The HEAD response tells everything.
+	if n := atomic.LoadInt32(&gets); n != 0 {
+		t.Errorf("sent %d GET requests for an empty file, want none", n)
+	}
+}
+
+func TestSoft404(t *testing.T) {
+	page := []byte("<html>not found</html>")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked.zip" {
+			// No Content-Length, the size is only known once downloaded.
+			if r.Method == http.MethodGet {
+				w.Write(page[:5])
+				w.(http.Flusher).Flush()
+				w.Write(page[5:])
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		http.ServeContent(w, r, "", fixedModTime, bytes.NewReader(page))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		opts DownloadOptions
+		path string
+		fail bool
+	}{
+		{"html for a zip", DownloadOptions{DetectSoft404: true}, "/a.zip", true},
+		{"html for a page", DownloadOptions{DetectSoft404: true}, "/index.html", false},
+		{"html without an extension", DownloadOptions{DetectSoft404: true}, "/report", false},
+		{"not detected", DownloadOptions{}, "/a.zip", false},
+		{"too small", DownloadOptions{MinExpectedSize: 100}, "/index.html", true},
+		{"large enough", DownloadOptions{MinExpectedSize: int64(len(page))}, "/index.html", false},
+		{"too small once downloaded", DownloadOptions{MinExpectedSize: 100}, "/chunked.zip", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.opts.DownloadDir = dir
+			_, err := NewDownloader(tt.opts).Download(srv.URL + tt.path)
+			if tt.fail != errors.Is(err, ErrSoft404) {
+				t.Fatalf("Download() error = %v, want ErrSoft404: %v", err, tt.fail)
+			}
+			if entries, _ := os.ReadDir(dir); tt.fail && len(entries) != 0 {
+				t.Errorf("the rejected page left %d files behind", len(entries))
+			}
+		})
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	srv := serve(map[string][]byte{"/a.bin": fixture(100), "/b.bin": fixture(12 << 20)})
+	defer srv.Close()
+
+	for _, verbose := range []bool{false, true} {
+		// A plain buffer, the messages of concurrent parts are serialized.
+		var out bytes.Buffer
+		d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), NumConcParts: 3, Verbose: verbose, Output: &out})
+		if _, err := d.Download(srv.URL+"/a.bin", srv.URL+"/b.bin"); err != nil {
+			t.Fatal(err)
+		}
+		if !verbose && out.Len() != 0 {
+			t.Errorf("printed %q without Verbose, want nothing", out.String())
+		}
+		if verbose && strings.Count(out.String(), "goroutine downloading file b.bin part") != 3 {
+			t.Errorf("printed %q with Verbose, want the 3 parts of b.bin", out.String())
+		}
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	srv := serve(map[string][]byte{"/a.bin": fixture(100), "/b.bin": fixture(200)})
+	defer srv.Close()
+	urls := []string{srv.URL + "/missing1.bin", srv.URL + "/a.bin", srv.URL + "/missing2.bin", srv.URL + "/b.bin"}
+
+	// By default every file is tried and the failures are collected.
+	d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), MaxLimitConcurrency: 1})
+	results, err := d.DownloadAll(context.Background(), urls...)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 2 || !errors.Is(err, ErrUnexpectedStatus) {
+		t.Fatalf("DownloadAll() error = %v, want a BatchError of the 2 missing files", err)
+	}
+	for _, i := range []int{1, 3} {
+		if results[i].Err != nil || results[i].Status != StatusDownloaded {
+			t.Errorf("result of %s = %+v, want it downloaded", urls[i], results[i])
+		}
+	}
+
+	// With FailFast the first failure stops the batch.
+	d = NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), MaxLimitConcurrency: 1, FailFast: true})
+	results, err = d.DownloadAll(context.Background(), urls...)
+	if !errors.Is(err, ErrUnexpectedStatus) || errors.As(err, &batchErr) {
+		t.Fatalf("DownloadAll() with FailFast error = %v, want the single first failure", err)
+	}
+	for _, i := range []int{1, 3} {
+		if results[i].Err != ErrNotStarted {
+			t.Errorf("result of %s = %+v, want ErrNotStarted", urls[i], results[i])
+		}
+	}
+}
+
+func TestFailFastCancelsRunning(t *testing.T) {
+	files := newTrickleServer()
+	defer files.Close()
+	// broken.bin fails once slow.bin is downloading.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken.bin" {
+			w.Header().Set("Content-Length", "100")
+			if r.Method == http.MethodGet {
+				<-files.started
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage(), FailFast: true})
+	start := time.Now()
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/slow.bin", srv.URL+"/broken.bin")
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("DownloadAll() error = %v, want the 500 of broken.bin", err)
+	}
+	// slow.bin would trickle for about 10s.
+	if elapsed := time.Since(start); elapsed > 5*time.Second || results[0].Err == nil {
+		t.Errorf("slow.bin = %+v after %v, want it cancelled", results[0], elapsed)
+	}
+}
+
+func TestRangesIgnoredOnGet(t *testing.T) {
+	data := fixture(12 << 20)
+	var mu sync.Mutex
+	var ranges []string
+	// The HEAD response advertises ranges, every GET gets the whole file.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	for _, storage := range []Storage{nil, newMemStorage()} {
+		dir := t.TempDir()
+		d := NewDownloader(DownloadOptions{DownloadDir: dir, Storage: storage, TempDir: t.TempDir(), NumConcParts: 3})
+		if _, err := d.Download(srv.URL + "/f.bin"); !errors.Is(err, ErrRangeNotSupported) {
+			t.Fatalf("Download() error = %v, want ErrRangeNotSupported", err)
+		}
+		// The full bodies never made it into a file.
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("the failed download left %d files behind", len(entries))
+		}
+		if m, ok := storage.(*memStorage); ok && m.file("f.bin") != nil {
+			t.Error("the failed download was stored")
+		}
+	}
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 3, FallbackToSingleStream: true})
+	mu.Lock()
+	ranges = nil
+	mu.Unlock()
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Concurrency != 1 {
+		t.Errorf("Concurrency = %d, want the single stream of the fallback", results[0].Concurrency)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "f.bin")); !bytes.Equal(b, data) {
+		t.Error("downloaded file differs")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("download dir has %d files, want only f.bin", len(entries))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if last, want := ranges[len(ranges)-1], fmt.Sprintf("bytes=0-%d", len(data)-1); last != want {
+		t.Errorf("the fallback requested the range %q, want %q", last, want)
+	}
+}
+
+func TestDownloadPathsOrdered(t *testing.T) {
+	files := make(map[string][]byte)
+	var urls []string
+	for i := 0; i < 8; i++ {
+		// The first files are the largest and finish last.
+		name := fmt.Sprintf("/f%d.bin", i)
+		files[name] = fixture((8 - i) * 100 << 10)
+	}
+	srv := serve(files)
+	defer srv.Close()
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			urls = append(urls, srv.URL+"/missing.bin")
+		}
+		urls = append(urls, fmt.Sprintf("%s/f%d.bin", srv.URL, i))
+	}
+
+	for run := 0; run < 5; run++ {
+		dir := t.TempDir()
+		d := NewDownloader(DownloadOptions{DownloadDir: dir})
+		paths, err := d.Download(urls...)
+		if err == nil {
+			t.Fatal("Download() with a missing url succeeded")
+		}
+		if len(paths) != 8 {
+			t.Fatalf("Download() = %q, want the 8 downloaded paths", paths)
+		}
+		for i, p := range paths {
+			if want := filepath.Join(dir, fmt.Sprintf("f%d.bin", i)); p != want {
+				t.Fatalf("Download() = %q, want the paths in the order of the urls", paths)
+			}
+		}
+
+		results, _ := NewDownloader(DownloadOptions{DownloadDir: t.TempDir()}).DownloadAll(context.Background(), urls...)
+		for i, r := range results {
+			if r.URL != urls[i] {
+				t.Fatalf("result %d is of %s, want %s", i, r.URL, urls[i])
+			}
+		}
+	}
+}
+
+func TestDuplicateURLs(t *testing.T) {
+	srv := serve(map[string][]byte{"/f.bin": fixture(100), "/g.bin": fixture(100)})
+	defer srv.Close()
+
+	u := srv.URL + "/f.bin"
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir})
+	results, err := d.DownloadAll(context.Background(), u, u, srv.URL+"/g.bin", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.Ranges()); got != 2 {
+		t.Errorf("got %d GET requests, want one per unique url", got)
+	}
+	want := filepath.Join(dir, "f.bin")
+	for _, i := range []int{0, 1, 3} {
+		if r := results[i]; r.URL != u || r.Path != want || r.Status != StatusDownloaded || r.Size != 100 {
+			t.Errorf("result %d = %+v, want the shared download of f.bin", i, r)
+		}
+	}
+
+	// A failing duplicate fails every occurrence.
+	results, err = d.DownloadAll(context.Background(), srv.URL+"/missing.bin", srv.URL+"/missing.bin")
+	if !errors.Is(err, ErrUnexpectedStatus) || results[0].Err == nil || results[1].Err == nil {
+		t.Errorf("DownloadAll() of a missing url twice = %v, %v, %v", err, results[0].Err, results[1].Err)
+	}
+
+	var done int
+	for ev := range NewDownloader(DownloadOptions{Storage: newMemStorage()}).DownloadStream(context.Background(), u, u, u) {
+		if ev.Type == FileDone {
+			done++
+		}
+	}
+	if done != 3 {
+		t.Errorf("got %d FileDone events, want one per occurrence", done)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	files := make(map[string][]byte)
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("/f%d.bin", i)] = fixture(100 + i)
+	}
+	srv := serve(files)
+	defer srv.Close()
+
+	// One Downloader serves all calls, odd ones include a missing file.
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, MaxLimitConcurrency: 3})
+	var wg sync.WaitGroup
+	for c := 0; c < 8; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			var urls []string
+			for i := c * 5; i < c*5+5; i++ {
+				urls = append(urls, fmt.Sprintf("%s/f%d.bin", srv.URL, i))
+			}
+			if c%2 == 1 {
+				urls = append(urls, fmt.Sprintf("%s/missing%d.bin", srv.URL, c))
+			}
+			paths, err := d.Download(urls...)
+			if (err != nil) != (c%2 == 1) {
+				t.Errorf("call %d error = %v", c, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("missing%d.bin", c)) {
+				t.Errorf("call %d error = %v, want only its own missing file", c, err)
+			}
+			if len(paths) != 5 {
+				t.Errorf("call %d = %q, want its 5 paths", c, paths)
+				return
+			}
+			for i, p := range paths {
+				if want := filepath.Join(dir, fmt.Sprintf("f%d.bin", c*5+i)); p != want {
+					t.Errorf("call %d = %q, want only its own files in order", c, paths)
+					break
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 40 {
+		t.Errorf("download dir has %d files, want 40", len(entries))
+	}
+	// A later call starts clean.
+	paths, err := d.Download(srv.URL + "/f0.bin")
+	if !errors.Is(err, ErrFileExists) || len(paths) != 0 {
+		t.Errorf("Download() of an existing file = %q, %v, want only ErrFileExists", paths, err)
+	}
+}
+
+func TestSequentialCalls(t *testing.T) {
+	srv := serve(map[string][]byte{"/a.bin": fixture(100), "/b.bin": fixture(200)})
+	defer srv.Close()
+
+	// A failed batch leaves nothing behind for the next one.
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir})
+	paths, err := d.Download(srv.URL+"/a.bin", srv.URL+"/missing.bin")
+	if err == nil || len(paths) != 1 {
+		t.Fatalf("first Download() = %q, %v, want a.bin and the missing file", paths, err)
+	}
+	paths, err = d.Download(srv.URL + "/b.bin")
+	if err != nil {
+		t.Errorf("second Download() error = %v, want none of the first call", err)
+	}
+	if want := []string{filepath.Join(dir, "b.bin")}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("second Download() = %q, want only its own %q", paths, want)
+	}
+}
@@ -0,0 +1,235 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anupam111/concurrent-downloader/internal/download"
+	"github.com/anupam111/concurrent-downloader/internal/download/downloadtest"
+)
+
+func TestDownloadSingleStream(t *testing.T) {
+	srv := downloadtest.NewFileServer(t.TempDir())
+	defer srv.Close()
+	url, content, err := srv.Fixture("small.bin", 100<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := download.NewDownloader(download.DownloadOptions{DownloadDir: t.TempDir(), NumConcParts: 4})
+	results, err := d.DownloadAll(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := downloadtest.SameFile(results[0].Path, content); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Concurrency != 1 {
+		t.Errorf("Concurrency = %d, want a single stream below the split threshold", results[0].Concurrency)
+	}
+	var gets int
+	for _, r := range srv.Requests("small.bin") {
+		if r.Method == "GET" {
+			gets++
+		}
+	}
+	if gets != 1 {
+		t.Errorf("got %d GET requests, want 1", gets)
+	}
+}
+
+func TestDownloadMultiPart(t *testing.T) {
+	srv := downloadtest.NewFileServer(t.TempDir())
+	defer srv.Close()
+	url, content, err := srv.Fixture("dir/large.bin", 25<<20+7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := download.NewDownloader(download.DownloadOptions{DownloadDir: t.TempDir(), NumConcParts: 4})
+	results, err := d.DownloadAll(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := downloadtest.SameFile(results[0].Path, content); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Concurrency != 4 {
+		t.Errorf("Concurrency = %d, want 4", results[0].Concurrency)
+	}
+	ranges := map[string]bool{}
+	for _, r := range srv.Requests("dir/large.bin") {
+		if r.Method == "GET" {
+			ranges[r.Header.Get("Range")] = true
+		}
+	}
+	if len(ranges) != 4 {
+		t.Errorf("got the ranges %v, want 4 parts", ranges)
+	}
+}
+
+func TestDownloadRetriesFailedPart(t *testing.T) {
+	srv := downloadtest.NewFileServer(t.TempDir())
+	defer srv.Close()
+	url, content, err := srv.Fixture("large.bin", 12<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The HEAD request passes, then a part fails with 503 and the next
+	// request for a part is cut off after 1MB.
+	srv.Fail("large.bin", downloadtest.Failure{}, downloadtest.Failure{Status: 503}, downloadtest.Failure{After: 1 << 20})
+
+	d := download.NewDownloader(download.DownloadOptions{DownloadDir: t.TempDir(), NumConcParts: 2, MaxRetries: 2})
+	results, err := d.DownloadAll(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := downloadtest.SameFile(results[0].Path, content); err != nil {
+		t.Fatal(err)
+	}
+	var gets int
+	for _, r := range srv.Requests("large.bin") {
+		if r.Method == "GET" {
+			gets++
+		}
+	}
+	if gets != 4 {
+		t.Errorf("got %d GET requests, want 2 parts plus 2 retries", gets)
+	}
+}
+
+func TestDownloadFailedPartWithoutRetries(t *testing.T) {
+	srv := downloadtest.NewFileServer(t.TempDir())
+	defer srv.Close()
+	url, _, err := srv.Fixture("large.bin", 12<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Fail("large.bin", downloadtest.Failure{}, downloadtest.Failure{Status: 503})
+
+	dir := t.TempDir()
+	d := download.NewDownloader(download.DownloadOptions{DownloadDir: dir, NumConcParts: 2})
+	if _, err := d.DownloadAll(context.Background(), url); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("DownloadAll() error = %v, want the 503 of the failed part", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "large.bin")); !os.IsNotExist(err) {
+		t.Errorf("a failed download left large.bin behind: %v", err)
+	}
+}
+
+func TestFailingPartCancelsOthers(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 12<<20)
+	var cancelled int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+			http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+		case strings.HasPrefix(r.Header.Get("Range"), "bytes=0-"):
+			http.Error(w, "broken part", http.StatusInternalServerError)
+		default:
+			// The other parts hang until they are cancelled.
+			w.Header().Set("Content-Range", "bytes */12582912")
+			w.WriteHeader(http.StatusPartialContent)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				atomic.AddInt32(&cancelled, 1)
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	d := download.NewDownloader(download.DownloadOptions{DownloadDir: t.TempDir(), NumConcParts: 4})
+	start := time.Now()
+	_, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("DownloadAll() error = %v, want the 500 of the failed part", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("DownloadAll() took %v, the other parts were not cancelled", elapsed)
+	}
+	// The handlers notice the closed connections shortly after.
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&cancelled) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&cancelled); got != 3 {
+		t.Errorf("%d of the 3 other parts were cancelled", got)
+	}
+}
+
+func TestFailedDownloadLeavesNoFile(t *testing.T) {
+	for _, size := range []int64{100 << 10, 12 << 20} {
+		srv := downloadtest.NewFileServer(t.TempDir())
+		url, _, err := srv.Fixture("f.bin", size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The HEAD request passes, the first GET is cut off midway and the
+		// request for the remaining bytes fails.
+		srv.Fail("f.bin", downloadtest.Failure{}, downloadtest.Failure{After: size / 4}, downloadtest.Failure{Status: 500})
+
+		dir := t.TempDir()
+		d := download.NewDownloader(download.DownloadOptions{DownloadDir: dir, NumConcParts: 2})
+		_, err = d.DownloadAll(context.Background(), url)
+		srv.Close()
+		if err == nil {
+			t.Fatalf("DownloadAll() of %d bytes cut off midway succeeded", size)
+		}
+		// Neither the final file nor its temp file is left behind.
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("the failed download of %d bytes left %d files behind", size, len(entries))
+		}
+	}
+}
+
+// mockClient is a download.DownloadClient recording its urls, the way users
+// mock the downloader.
+type mockClient struct {
+	urls []string
+}
+
+func (m *mockClient) Download(fileUrls ...string) ([]string, error) {
+	m.urls = append(m.urls, fileUrls...)
+	return []string{"/mock/a.bin"}, nil
+}
+
+// fetch is code under test depending on the interface only.
+func fetch(c download.DownloadClient, url string) (string, error) {
+	paths, err := c.Download(url)
+	if err != nil {
+		return "", err
+	}
+	return paths[0], nil
+}
+
+func TestDownloadClient(t *testing.T) {
+	mock := &mockClient{}
+	if path, err := fetch(mock, "http://example.com/a.bin"); err != nil || path != "/mock/a.bin" || len(mock.urls) != 1 {
+		t.Errorf("fetch() with the mock = %q, %v, urls %q", path, err, mock.urls)
+	}
+
+	srv := downloadtest.NewFileServer(t.TempDir())
+	defer srv.Close()
+	url, content, err := srv.Fixture("a.bin", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c download.DownloadClient = download.NewDownloader(download.DownloadOptions{DownloadDir: t.TempDir()})
+	path, err := fetch(c, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := downloadtest.SameFile(path, content); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,109 @@
+// Package downloadtest provides a fake of download.DownloadClient for
+// testing code built on it without network access, and a FileServer for
+// integration testing the downloader against a real http server.
+package downloadtest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/anupam111/concurrent-downloader/internal/download"
+)
+
+// FakeDownloader serves the contents and errors configured per url and
+// records every call. Files are written into Dir named like the real
+// downloader names them. It is safe for concurrent use.
+type FakeDownloader struct {
+	Dir string
+
+	mu       sync.Mutex
+	contents map[string][]byte
+	errs     map[string]error
+	calls    [][]string
+}
+
+var _ download.DownloadClient = (*FakeDownloader)(nil)
+
+// NewFakeDownloader returns a FakeDownloader writing into dir.
+func NewFakeDownloader(dir string) *FakeDownloader {
+	return &FakeDownloader{Dir: dir, contents: make(map[string][]byte), errs: make(map[string]error)}
+}
+
+// SetContent makes downloads of url succeed with content.
+func (f *FakeDownloader) SetContent(url string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.contents[url] = content
+	delete(f.errs, url)
+}
+
+// SetError makes downloads of url fail with err.
+func (f *FakeDownloader) SetError(url string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs[url] = err
+	delete(f.contents, url)
+}
+
+// Calls returns the urls of every Download call so far, in call order.
+func (f *FakeDownloader) Calls() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := make([][]string, len(f.calls))
+	for i, c := range f.calls {
+		calls[i] = append([]string(nil), c...)
+	}
+	return calls
+}
+
+// Download writes the content configured for every url and returns the
+// paths of the files. Urls with an error, or without any configuration,
+// fail; like the real downloader the other urls are still written and the
+// failures are returned together as a download.BatchError.
+func (f *FakeDownloader) Download(fileUrls ...string) (downloadPaths []string, err error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, append([]string(nil), fileUrls...))
+	f.mu.Unlock()
+
+	var errs []error
+	for _, fileUrl := range fileUrls {
+		path, err := f.download(fileUrl)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		downloadPaths = append(downloadPaths, path)
+	}
+	switch len(errs) {
+	case 0:
+		return downloadPaths, nil
+	case 1:
+		return downloadPaths, errs[0]
+	}
+	return downloadPaths, &download.BatchError{Errs: errs}
+}
+
+func (f *FakeDownloader) download(fileUrl string) (string, error) {
+	f.mu.Lock()
+	content, ok := f.contents[fileUrl]
+	err := f.errs[fileUrl]
+	f.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%w: no fake content for %s", download.ErrUnexpectedStatus, fileUrl)
+	}
+	name, _ := download.URLName(fileUrl, nil)
+	switch name {
+	case "", ".", "..", "/":
+		return "", fmt.Errorf("%w %q", download.ErrInvalidFileName, name)
+	}
+	path := filepath.Join(f.Dir, name)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return "", fmt.Errorf("error while writing fake file %s: %w", path, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,104 @@
+package downloadtest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/anupam111/concurrent-downloader/internal/download"
+)
+
+func TestFakeDownloader(t *testing.T) {
+	dir := t.TempDir()
+	f := NewFakeDownloader(dir)
+	f.SetContent("http://example.com/a.bin", []byte("a"))
+	f.SetContent("http://example.com/dir/b.bin?x=1", []byte("b"))
+
+	var c download.DownloadClient = f
+	paths, err := c.Download("http://example.com/a.bin", "http://example.com/dir/b.bin?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a.bin"), filepath.Join(dir, "b.bin")}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("Download() = %q, want %q", paths, want)
+	}
+	for i, content := range []string{"a", "b"} {
+		if err := SameFile(paths[i], []byte(content)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestFakeDownloaderErrors(t *testing.T) {
+	f := NewFakeDownloader(t.TempDir())
+	f.SetContent("http://example.com/a.bin", []byte("a"))
+	boom := errors.New("boom")
+	f.SetError("http://example.com/b.bin", boom)
+
+	// A single failure is returned as is.
+	if _, err := f.Download("http://example.com/b.bin"); err != boom {
+		t.Errorf("Download() error = %v, want the configured error", err)
+	}
+	// Like the real downloader the other files are still written.
+	paths, err := f.Download("http://example.com/a.bin", "http://example.com/b.bin", "http://example.com/unknown.bin")
+	var batchErr *download.BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 2 || !errors.Is(err, boom) || !errors.Is(err, download.ErrUnexpectedStatus) {
+		t.Fatalf("Download() error = %v, want a BatchError of the configured and the unknown url", err)
+	}
+	if len(paths) != 1 {
+		t.Errorf("Download() = %q, want the path of a.bin", paths)
+	}
+	f.SetContent("http://example.com/", []byte("index"))
+	if _, err := f.Download("http://example.com/"); !errors.Is(err, download.ErrInvalidFileName) {
+		t.Errorf("Download() of a url without a name error = %v, want ErrInvalidFileName", err)
+	}
+
+	// Setting content replaces an error and the other way round.
+	f.SetContent("http://example.com/b.bin", []byte("b"))
+	if _, err := f.Download("http://example.com/b.bin"); err != nil {
+		t.Errorf("Download() after SetContent error = %v", err)
+	}
+	f.SetError("http://example.com/a.bin", boom)
+	if _, err := f.Download("http://example.com/a.bin"); err != boom {
+		t.Errorf("Download() after SetError error = %v", err)
+	}
+}
+
+func TestFakeDownloaderCalls(t *testing.T) {
+	f := NewFakeDownloader(t.TempDir())
+	f.SetContent("http://example.com/a.bin", []byte("a"))
+	f.Download("http://example.com/a.bin")
+	f.Download("http://example.com/a.bin", "http://example.com/missing.bin")
+	f.Download()
+
+	want := [][]string{{"http://example.com/a.bin"}, {"http://example.com/a.bin", "http://example.com/missing.bin"}, nil}
+	calls := f.Calls()
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("Calls() = %q, want %q", calls, want)
+	}
+	// The returned calls are a copy.
+	calls[0][0] = "changed"
+	if f.Calls()[0][0] != "http://example.com/a.bin" {
+		t.Error("changing the result of Calls() changed the recorded calls")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Download("http://example.com/a.bin")
+		}()
+	}
+	wg.Wait()
+	if n := len(f.Calls()); n != 13 {
+		t.Errorf("recorded %d calls, want 13", n)
+	}
+	if _, err := os.Stat(filepath.Join(f.Dir, "a.bin")); err != nil {
+		t.Error(err)
+	}
+}
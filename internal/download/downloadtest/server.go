@@ -0,0 +1,46 @@
+package downloadtest
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/anupam111/concurrent-downloader/internal/download/internal/testserver"
+)
+
+// FileServer is a real http server of the files in Dir for integration
+// testing the downloader, with failures injected per file. It is the
+// server the internal tests of download use, see testserver.FileServer.
+type FileServer = testserver.FileServer
+
+// Failure is a failure injected into a request for a file of a FileServer.
+type Failure = testserver.Failure
+
+// NewFileServer starts a FileServer of the files in dir. The caller must
+// Close it.
+func NewFileServer(dir string) *FileServer {
+	return testserver.NewFileServer(dir)
+}
+
+// SameFile returns an error unless the file at path holds exactly want.
+func SameFile(path string, want []byte) error {
+	got, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error while reading %s: %w", path, err)
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("%s has %d bytes, want %d", path, len(got), len(want))
+	}
+	if i := firstDiff(got, want); i >= 0 {
+		return fmt.Errorf("%s differs from the expected content at byte %d", path, i)
+	}
+	return nil
+}
+
+func firstDiff(a, b []byte) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
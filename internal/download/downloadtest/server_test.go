@@ -0,0 +1,31 @@
+package downloadtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSameFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		want []byte
+		ok   bool
+	}{
+		{[]byte("abc"), true},
+		{[]byte("abd"), false},
+		{[]byte("ab"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if err := SameFile(path, tt.want); (err == nil) != tt.ok {
+			t.Errorf("SameFile(%q) = %v, want ok: %v", tt.want, err, tt.ok)
+		}
+	}
+	if err := SameFile(path+".missing", nil); err == nil {
+		t.Error("SameFile() of a missing file succeeded")
+	}
+}
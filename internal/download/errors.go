@@ -0,0 +1,150 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Errors returned, possibly wrapped, by the downloads. Use errors.Is to
+// check for them.
+var (
+	// ErrFileExists is returned when the output file already exists.
+	ErrFileExists = errors.New("file already exists")
+	// ErrInvalidFileName is returned for file names which are empty or
+	// could escape the download directory.
+	ErrInvalidFileName = errors.New("invalid file name")
+	// ErrUnsupportedScheme is returned for urls other than http, https and
+	// ftp.
+	ErrUnsupportedScheme = errors.New("unsupported url scheme")
+	// ErrUnexpectedStatus is returned when the server answers with a status
+	// code the download can't continue with.
+	ErrUnexpectedStatus = errors.New("unexpected status code")
+	// ErrTooManyRedirects is returned when a request is redirected more
+	// often than DownloadOptions.MaxRedirects allows.
+	ErrTooManyRedirects = errors.New("too many redirects")
+	// ErrContentType is returned when the Content-Type of a file is not one
+	// of DownloadOptions.AllowedContentTypes.
+	ErrContentType = errors.New("content type not allowed")
+	// ErrSoft404 is returned for responses which look like an error page
+	// served with status 200, see DownloadOptions.MinExpectedSize and
+	// DownloadOptions.DetectSoft404.
+	ErrSoft404 = errors.New("response looks like an error page")
+	// ErrRangeNotSupported is returned when the server ignores the Range
+	// header of a request which needs a partial response.
+	ErrRangeNotSupported = errors.New("server does not support ranges")
+	// ErrInvalidRange is returned by DownloadRange for ranges which are
+	// malformed or outside of the file.
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrShortWrite is returned when fewer bytes were written than the
+	// server announced.
+	ErrShortWrite = errors.New("short write")
+	// ErrSizeChanged is returned when a part of a file announces another
+	// length than its HEAD response, see DownloadOptions.VerifyContentRange.
+	ErrSizeChanged = errors.New("file size changed during download")
+	// ErrUnexpectedSize is returned for a file whose size differs from its
+	// DownloadOptions.ExpectedSizes entry.
+	ErrUnexpectedSize = errors.New("file size differs from the expected size")
+	// ErrSizeUnknown is returned when the size probe of a file gives up
+	// because the server announced a length which can't be parsed.
+	ErrSizeUnknown = errors.New("file size is unknown")
+	// ErrStalled is returned when a part did not receive any bytes within
+	// DownloadOptions.StallTimeout.
+	ErrStalled = errors.New("no data received within stall timeout")
+	// ErrChecksumMismatch is returned when a file doesn't match the digest
+	// announced by the server or its DownloadOptions.ExpectedHashes entry,
+	// see DownloadOptions.VerifyServerDigest.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrRetryBudgetExhausted is returned for a failure which was not
+	// retried because DownloadOptions.MaxTotalRetries was used up.
+	ErrRetryBudgetExhausted = errors.New("retry budget of the batch is exhausted")
+	// ErrTooManyPartFailures is returned for a file which was aborted
+	// because more of its parts failed than
+	// DownloadOptions.PartFailureThreshold allows.
+	ErrTooManyPartFailures = errors.New("too many parts of the file failed")
+	// ErrTotalBytesExceeded is returned when a batch is larger than
+	// DownloadOptions.MaxTotalBytes.
+	ErrTotalBytesExceeded = errors.New("download exceeds the total size limit")
+	// ErrNotStarted is reported for the urls of a batch which were never
+	// started because another url failed with DownloadOptions.FailFast.
+	ErrNotStarted = errors.New("download not started")
+	// ErrBatchTimeout is matched by the BatchTimeoutError returned when a
+	// batch takes longer than DownloadOptions.BatchTimeout.
+	ErrBatchTimeout = errors.New("batch timed out")
+	// ErrCancelled is reported for urls stopped by Downloader.CancelURL.
+	ErrCancelled = errors.New("download cancelled")
+)
+
+// BatchError holds the failures of all files of a batch downloaded without
+// DownloadOptions.FailFast. errors.Is and errors.As match any of them.
+type BatchError struct {
+	Errs []error
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d downloads failed: %s", len(e.Errs), strings.Join(msgs, "; "))
+}
+
+func (e *BatchError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *BatchError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// BatchTimeoutError is returned when a batch takes longer than
+// DownloadOptions.BatchTimeout. errors.Is matches ErrBatchTimeout and
+// context.DeadlineExceeded, and Err, the failures of the batch like a
+// BatchError, stays reachable with errors.Is and errors.As.
+type BatchTimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *BatchTimeoutError) Error() string {
+	return fmt.Sprintf("%v after %v: %v", ErrBatchTimeout, e.Timeout, e.Err)
+}
+
+func (e *BatchTimeoutError) Is(target error) bool {
+	return target == ErrBatchTimeout || target == context.DeadlineExceeded
+}
+
+func (e *BatchTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// batchError returns the errors of the failed results, a single one as is
+// and several as a BatchError. Files cancelled with CancelURL are no
+// failure.
+func batchError(results []DownloadResult) error {
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, ErrNotStarted) && !errors.Is(r.Err, ErrCancelled) {
+			errs = append(errs, r.Err)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return &BatchError{Errs: errs}
+}
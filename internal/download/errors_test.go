@@ -0,0 +1,191 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchTimeoutError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/slow") && r.Method == http.MethodGet {
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("a"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(fixture(100)))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	const timeout = 300 * time.Millisecond
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, BatchTimeout: timeout})
+	start := time.Now()
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/fast.bin", srv.URL+"/slow1.bin", srv.URL+"/slow2.bin")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("DownloadAll() took %v, want about %v", elapsed, timeout)
+	}
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err == nil {
+		t.Fatalf("errors of the results = %v, %v, %v, want only the slow ones failed", results[0].Err, results[1].Err, results[2].Err)
+	}
+
+	var timeoutErr *BatchTimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Timeout != timeout {
+		t.Fatalf("DownloadAll() error = %v, want a *BatchTimeoutError after %v", err, timeout)
+	}
+	for _, target := range []error{ErrBatchTimeout, context.DeadlineExceeded} {
+		if !errors.Is(err, target) {
+			t.Errorf("errors.Is(%v, %v) = false", err, target)
+		}
+	}
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 2 {
+		t.Errorf("DownloadAll() error = %v, want it to wrap the BatchError of the 2 slow files", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("download directory has %d entries, want only the fast file", len(entries))
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	small, large := fixture(100), fixture(12<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small.bin":
+			http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(small))
+		case "/noranges.bin":
+			// Ranges are ignored, the whole file is sent.
+			w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+			if r.Method == http.MethodGet {
+				w.Write(large)
+			}
+		case "/short.bin":
+			if r.Method == http.MethodHead {
+				w.Header().Set("Content-Length", "5000")
+				return
+			}
+			w.Write(small)
+		case "/badrange.bin":
+			// HEAD is rejected and the range probe announces no usable size.
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Range", "bytes 0-0/many")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(small[:1])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		opts DownloadOptions
+		run  func(d *Downloader) error
+		want error
+	}{
+		{"file exists", DownloadOptions{}, func(d *Downloader) error {
+			if _, err := d.Download(srv.URL + "/small.bin"); err != nil {
+				return err
+			}
+			_, err := d.Download(srv.URL + "/small.bin")
+			return err
+		}, ErrFileExists},
+		{"not found", DownloadOptions{}, func(d *Downloader) error {
+			_, err := d.Download(srv.URL + "/missing.bin")
+			return err
+		}, ErrUnexpectedStatus},
+		{"scheme", DownloadOptions{}, func(d *Downloader) error {
+			_, err := d.Download("gopher://example.com/f.bin")
+			return err
+		}, ErrUnsupportedScheme},
+		{"file name", DownloadOptions{}, func(d *Downloader) error {
+			_, err := d.DownloadNamed(map[string]string{srv.URL + "/small.bin": "../f.bin"})
+			return err
+		}, ErrInvalidFileName},
+		{"range", DownloadOptions{}, func(d *Downloader) error {
+			return d.DownloadRange(srv.URL+"/small.bin", 50, 200, io.Discard)
+		}, ErrInvalidRange},
+		{"ranges ignored", DownloadOptions{NumConcParts: 2}, func(d *Downloader) error {
+			_, err := d.Download(srv.URL + "/noranges.bin")
+			return err
+		}, ErrRangeNotSupported},
+		{"short", DownloadOptions{StrictSize: true}, func(d *Downloader) error {
+			_, err := d.Download(srv.URL + "/short.bin")
+			return err
+		}, ErrShortWrite},
+		{"checksum", DownloadOptions{ExpectedHashes: map[string]string{srv.URL + "/small.bin": sha256Hex(large)}}, func(d *Downloader) error {
+			_, err := d.Download(srv.URL + "/small.bin")
+			return err
+		}, ErrChecksumMismatch},
+		{"size unknown", DownloadOptions{}, func(d *Downloader) error {
+			_, err := d.Download(srv.URL + "/badrange.bin")
+			return err
+		}, ErrSizeUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.DownloadDir = t.TempDir()
+			if err := tt.run(NewDownloader(tt.opts)); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "100")
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		switch r.URL.Path {
+		case "/long.bin":
+			w.Write(bytes.Repeat([]byte("x"), 5000))
+		case "/binary.bin":
+			w.Write([]byte("denied\xff\xfe"))
+		default:
+			w.Write([]byte(`{"error": "signature expired"}` + "\n"))
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage()})
+	_, err := d.Download(srv.URL + "/f.bin")
+	if err == nil || !strings.Contains(err.Error(), `403: {"error": "signature expired"}`) {
+		t.Errorf("Download() error = %v, want the status and the body", err)
+	}
+	if !errors.Is(err, ErrUnexpectedStatus) {
+		t.Errorf("Download() error = %v, want ErrUnexpectedStatus", err)
+	}
+
+	// Long bodies are cut to maxErrorBody bytes.
+	_, err = d.Download(srv.URL + "/long.bin")
+	if err == nil || !strings.HasSuffix(err.Error(), strings.Repeat("x", maxErrorBody)+"...") || strings.Contains(err.Error(), strings.Repeat("x", maxErrorBody+1)) {
+		t.Errorf("Download() of a long error body error has %d bytes, want the body cut to %d", len(fmt.Sprint(err)), maxErrorBody)
+	}
+	// Invalid UTF-8 is dropped.
+	_, err = d.Download(srv.URL + "/binary.bin")
+	if err == nil || !strings.HasSuffix(err.Error(), "403: denied") {
+		t.Errorf("Download() of a binary error body error = %q", err)
+	}
+}
@@ -0,0 +1,192 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// EventType is the kind of an Event.
+type EventType int
+
+const (
+	// Started is sent when the download of a file starts.
+	Started EventType = iota
+	// Progress is sent whenever bytes of a file are written.
+	Progress
+	// PartDone is sent when a part of a file is completely downloaded.
+	PartDone
+	// FileDone is sent when a file is downloaded, or kept as not modified.
+	FileDone
+	// Failed is sent when a file could not be downloaded.
+	Failed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Started:
+		return "Started"
+	case Progress:
+		return "Progress"
+	case PartDone:
+		return "PartDone"
+	case FileDone:
+		return "FileDone"
+	case Failed:
+		return "Failed"
+	}
+	return "EventType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Event is sent by DownloadStream as downloads make progress.
+type Event struct {
+	Type EventType
+	URL  string
+	// Part is the index of the part for PartDone events.
+	Part int
+	// Downloaded is the number of bytes of the file downloaded so far and
+	// Total its size, zero when unknown. Set for Progress events.
+	Downloaded int64
+	Total      int64
+	// Speed is the smoothed download speed of the file in bytes per second
+	// and ETA the estimated time until it is complete. Both are set for
+	// Progress events once known, ETA only with a known Total.
+	Speed float64
+	ETA   time.Duration
+	// Result is set for FileDone and Failed events.
+	Result DownloadResult
+}
+
+// DownloadStream is like DownloadAll but reports the downloads as a stream of
+// events instead of returning at the end. Every url gets either a FileDone or
+// a Failed event and the channel is closed once all downloads terminated.
+// The channel must be drained, downloads block while it is full. Once ctx
+// is done events which don't fit into the channel are dropped, so a
+// consumer may stop reading after cancelling.
+func (d *Downloader) DownloadStream(ctx context.Context, fileUrls ...string) <-chan Event {
+	events := make(chan Event, 16)
+	go func() {
+		defer close(events)
+		emit := func(ev Event) {
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				select {
+				case events <- ev:
+				default:
+				}
+			}
+		}
+		results, _ := d.downloadFiles(withEvents(ctx, emit), urlRequests(fileUrls))
+		for _, r := range results {
+			if errors.Is(r.Err, ErrNotStarted) {
+				emit(Event{Type: Failed, URL: r.URL, Result: r})
+			}
+		}
+	}()
+	return events
+}
+
+// DownloadAsync is like DownloadAll but sends the result of every url on the
+// returned channel as soon as it finished, successfully or not. At most
+// MaxLimitConcurrency files are downloaded at once. The channel is closed
+// after the last result, it must be drained until ctx is done. Results
+// which don't fit into the channel after that are dropped.
+func (d *Downloader) DownloadAsync(ctx context.Context, fileUrls ...string) <-chan DownloadResult {
+	results := make(chan DownloadResult, 16)
+	go func() {
+		defer close(results)
+		send := func(r DownloadResult) {
+			select {
+			case results <- r:
+			case <-ctx.Done():
+				select {
+				case results <- r:
+				default:
+				}
+			}
+		}
+		emit := func(ev Event) {
+			if ev.Type == FileDone || ev.Type == Failed {
+				send(ev.Result)
+			}
+		}
+		all, _ := d.downloadFiles(withEvents(ctx, emit), urlRequests(fileUrls))
+		for _, r := range all {
+			if errors.Is(r.Err, ErrNotStarted) {
+				send(r)
+			}
+		}
+	}()
+	return results
+}
+
+type eventsKey struct{}
+
+// withEvents returns a context which makes the download engine call emit
+// for every event.
+func withEvents(ctx context.Context, emit func(Event)) context.Context {
+	return context.WithValue(ctx, eventsKey{}, emit)
+}
+
+// emitEvent sends ev if ctx was created by withEvents.
+func emitEvent(ctx context.Context, ev Event) {
+	if emit, ok := ctx.Value(eventsKey{}).(func(Event)); ok {
+		emit(ev)
+	}
+}
+
+// wantsEvents reports whether ctx was created by withEvents.
+func wantsEvents(ctx context.Context) bool {
+	_, ok := ctx.Value(eventsKey{}).(func(Event))
+	return ok
+}
+
+// progressWriter emits a Progress event for every write to w. All parts of
+// a file share the downloaded counter.
+type progressWriter struct {
+	ctx        context.Context
+	w          io.Writer
+	url        string
+	total      int64
+	downloaded *int64
+	speed      *speedMeter
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if n > 0 {
+		done := atomic.AddInt64(p.downloaded, int64(n))
+		ev := Event{Type: Progress, URL: p.url, Downloaded: done, Total: p.total}
+		if p.speed != nil {
+			ev.Speed = p.speed.add(int64(n), time.Now())
+			if p.total > 0 {
+				ev.ETA = eta(p.total-done, ev.Speed)
+			}
+		}
+		emitEvent(p.ctx, ev)
+	}
+	return n, err
+}
+
+// partProgressWriter calls PartProgressFunc for every write to a part.
+type partProgressWriter struct {
+	w          io.Writer
+	fn         func(url string, partIndex int, downloaded, total int64)
+	url        string
+	part       int
+	downloaded int64
+	total      int64
+}
+
+func (p *partProgressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if n > 0 {
+		p.downloaded += int64(n)
+		p.fn(p.url, p.part, p.downloaded, p.total)
+	}
+	return n, err
+}
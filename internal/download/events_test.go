@@ -0,0 +1,278 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// partProgress records the PartProgressFunc calls of a download.
+type partProgress struct {
+	t     *testing.T
+	mu    sync.Mutex
+	first map[int]int64
+	last  map[int][2]int64
+}
+
+func newPartProgress(t *testing.T) *partProgress {
+	return &partProgress{t: t, first: make(map[int]int64), last: make(map[int][2]int64)}
+}
+
+func (p *partProgress) record(url string, part int, downloaded, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.first[part]; !ok {
+		p.first[part] = downloaded
+	}
+	if prev, ok := p.last[part]; ok && prev[0] > downloaded {
+		p.t.Errorf("part %d went back from %d to %d bytes", part, prev[0], downloaded)
+	}
+	p.last[part] = [2]int64{downloaded, total}
+}
+
+func TestPartProgressFunc(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	progress := newPartProgress(t)
+	d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), NumConcParts: 4, PartProgressFunc: progress.record})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if len(progress.last) != 4 {
+		t.Fatalf("got progress of parts %v, want 4 parts", progress.last)
+	}
+	for part, v := range progress.last {
+		if v[0] != v[1] || v[1] != 3<<20 {
+			t.Errorf("part %d ended at %d of %d bytes, want %d of %d", part, v[0], v[1], 3<<20, 3<<20)
+		}
+	}
+}
+
+func TestPartProgressFuncResumed(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	// Part 1 of 4 was partially staged by an earlier run.
+	tmp := t.TempDir()
+	const have = 1000
+	per := int64(len(data) / 4)
+	header := http.Header{"Last-Modified": {"Sun, 13 Sep 2020 12:26:40 GMT"}}
+	staged := stagedPartPath(tmp, "f.bin", srv.URL+"/f.bin", header, int64(len(data)), 4, 1)
+	if err := os.WriteFile(staged, data[per:per+have], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := newMemStorage()
+	progress := newPartProgress(t)
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: tmp, Resume: true, NumConcParts: 4, PartProgressFunc: progress.record})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Fatal("downloaded file differs")
+	}
+	if got := progress.first[1]; got <= have {
+		t.Errorf("resumed part 1 first reported %d bytes, want more than the %d staged", got, have)
+	}
+	if v := progress.last[1]; v[0] != per || v[1] != per {
+		t.Errorf("resumed part 1 ended at %d of %d bytes, want %d of %d", v[0], v[1], per, per)
+	}
+}
+
+func TestOnFileComplete(t *testing.T) {
+	srv := serve(map[string][]byte{"/a.bin": fixture(10), "/b.bin": fixture(1000), "/c.bin": fixture(12 << 20)})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var mu sync.Mutex
+	calls := map[string]int{}
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 3, MaxLimitConcurrency: 3, OnFileComplete: func(r DownloadResult) {
+		// The file already has its final name.
+		if _, err := os.Stat(r.Path); err != nil {
+			t.Errorf("OnFileComplete(%s): %v", r.URL, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		calls[r.Path]++
+	}})
+	d.DownloadAll(context.Background(), srv.URL+"/a.bin", srv.URL+"/b.bin", srv.URL+"/c.bin", srv.URL+"/missing.bin")
+
+	want := map[string]int{filepath.Join(dir, "a.bin"): 1, filepath.Join(dir, "b.bin"): 1, filepath.Join(dir, "c.bin"): 1}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("OnFileComplete calls = %v, want one per downloaded file", calls)
+	}
+}
+
+func TestDownloadStream(t *testing.T) {
+	large := fixture(12 << 20)
+	files := serve(map[string][]byte{"/large.bin": large, "/broken.bin": fixture(1000)})
+	defer files.Close()
+	// GETs of broken.bin fail after a successful HEAD request.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/broken.bin" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, files.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage(), NumConcParts: 3})
+	byURL := map[string][]Event{}
+	for ev := range d.DownloadStream(context.Background(), srv.URL+"/large.bin", srv.URL+"/broken.bin", srv.URL+"/missing.bin") {
+		byURL[ev.URL] = append(byURL[ev.URL], ev)
+	}
+
+	events := byURL[srv.URL+"/large.bin"]
+	if len(events) < 2 || events[0].Type != Started || events[len(events)-1].Type != FileDone {
+		t.Fatalf("events of large.bin = %v, want Started first and FileDone last", types(events))
+	}
+	var partsDone int
+	var downloaded int64
+	for _, ev := range events[1 : len(events)-1] {
+		switch ev.Type {
+		case PartDone:
+			partsDone++
+		case Progress:
+			if ev.Downloaded < downloaded || ev.Total != int64(len(large)) {
+				t.Errorf("Progress %d/%d after %d, want growing progress of %d bytes", ev.Downloaded, ev.Total, downloaded, len(large))
+			}
+			downloaded = ev.Downloaded
+		default:
+			t.Errorf("unexpected %v event of large.bin", ev.Type)
+		}
+	}
+	if partsDone != 3 || downloaded != int64(len(large)) {
+		t.Errorf("got %d PartDone events and progress up to %d bytes, want 3 parts and %d bytes", partsDone, downloaded, len(large))
+	}
+	if r := events[len(events)-1].Result; r.Err != nil || r.Size != int64(len(large)) {
+		t.Errorf("FileDone result = %+v, want the downloaded file", r)
+	}
+
+	if got := types(byURL[srv.URL+"/broken.bin"]); !reflect.DeepEqual(got, []EventType{Started, Failed}) {
+		t.Errorf("events of broken.bin = %v, want Started and Failed", got)
+	}
+	// The HEAD request fails before the download starts.
+	failed := byURL[srv.URL+"/missing.bin"]
+	if got := types(failed); !reflect.DeepEqual(got, []EventType{Failed}) {
+		t.Fatalf("events of missing.bin = %v, want a single Failed", got)
+	}
+	if r := failed[0].Result; r.Err == nil || r.Status != StatusFailed {
+		t.Errorf("Failed result = %+v, want the error", r)
+	}
+}
+
+func types(events []Event) []EventType {
+	var ts []EventType
+	for _, ev := range events {
+		ts = append(ts, ev.Type)
+	}
+	return ts
+}
+
+func TestDownloadAsync(t *testing.T) {
+	files := serve(map[string][]byte{"/a.bin": fixture(100), "/b.bin": fixture(200), "/slow.bin": fixture(300)})
+	defer files.Close()
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var running, peak int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			if running++; running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				running--
+				mu.Unlock()
+			}()
+			if r.URL.Path == "/slow.bin" {
+				<-release
+			}
+		}
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage(), MaxLimitConcurrency: 2})
+	results := d.DownloadAsync(context.Background(), srv.URL+"/slow.bin", srv.URL+"/a.bin", srv.URL+"/missing.bin", srv.URL+"/b.bin")
+	// Everything but slow.bin finishes while slow.bin holds one of the 2
+	// slots.
+	got := map[string]DownloadResult{}
+	for i := 0; i < 3; i++ {
+		r := <-results
+		got[r.URL[len(srv.URL):]] = r
+	}
+	if _, ok := got["/slow.bin"]; ok {
+		t.Fatal("slow.bin was sent before it finished")
+	}
+	if r := got["/missing.bin"]; r.Err == nil {
+		t.Errorf("result of missing.bin = %+v, want the error", r)
+	}
+	for _, name := range []string{"/a.bin", "/b.bin"} {
+		if r := got[name]; r.Err != nil || r.Status != StatusDownloaded {
+			t.Errorf("result of %s = %+v, want it downloaded", name, r)
+		}
+	}
+
+	close(release)
+	if r, ok := <-results; !ok || r.URL != srv.URL+"/slow.bin" || r.Err != nil {
+		t.Fatalf("last result = %+v, %v, want slow.bin", r, ok)
+	}
+	if r, ok := <-results; ok {
+		t.Errorf("got %+v after the last result, want the channel closed", r)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if peak > 2 {
+		t.Errorf("%d files were downloaded at once, want at most MaxLimitConcurrency 2", peak)
+	}
+}
+
+func TestAbandonedStreams(t *testing.T) {
+	files := make(map[string][]byte)
+	var urls []string
+	srv := serve(files)
+	defer srv.Close()
+	for i := 0; i < 40; i++ {
+		name := "/f" + strconv.Itoa(i) + ".bin"
+		files[name] = fixture(1 << 20)
+		urls = append(urls, srv.URL+name)
+	}
+
+	// The consumers read one value, cancel and stop reading. Without
+	// keep-alives no connection goroutines outlive the downloads.
+	before := runtime.NumGoroutine()
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage(), MaxLimitConcurrency: 4, DisableKeepAlives: true})
+	for _, stream := range []func(context.Context) bool{
+		func(ctx context.Context) bool { _, ok := <-d.DownloadStream(ctx, urls...); return ok },
+		func(ctx context.Context) bool { _, ok := <-d.DownloadAsync(ctx, urls...); return ok },
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		if !stream(ctx) {
+			t.Fatal("the channel was closed before the first value")
+		}
+		cancel()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines are left after abandoning the streams, want %d", n, before)
+	}
+}
@@ -0,0 +1,165 @@
+package download
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveExt returns the archive extension of name which ExtractArchives
+// handles, or "" for other files.
+func archiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[len(name)-len(ext):]
+		}
+	}
+	return ""
+}
+
+// extract extracts the archive at path, downloaded for req, if
+// ExtractArchives is set and path is a local zip or gzipped tar file. It
+// returns the extracted files.
+func (d *Downloader) extract(req fileRequest, path string) ([]string, error) {
+	if !d.downloadOptions.ExtractArchives {
+		return nil, nil
+	}
+	if _, ok := d.storage(req).(LocalStorage); !ok {
+		return nil, nil
+	}
+	ext := archiveExt(path)
+	if ext == "" {
+		return nil, nil
+	}
+	files, err := extractArchive(path, strings.TrimSuffix(path, ext), strings.ToLower(ext) == ".zip")
+	if err != nil {
+		return nil, fmt.Errorf("error while extracting %s: %w", path, err)
+	}
+	return files, nil
+}
+
+// extractArchive extracts the archive at path into the new directory dir.
+// Entries which would end up outside of dir fail with ErrInvalidFileName,
+// dir is removed again on any failure. Links and special files are
+// skipped.
+func extractArchive(path, dir string, isZip bool) (files []string, err error) {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrFileExists, dir)
+		}
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	// add writes one entry, it is called with a nil r for directories.
+	add := func(name string, mode os.FileMode, r io.Reader) error {
+		if err := validateFileName(name); err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(strings.ReplaceAll(name, "\\", "/")))
+		rel, err := filepath.Rel(dir, target)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%w: archive entry %q escapes %s", ErrInvalidFileName, name, dir)
+		}
+		if r == nil {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if mode.Perm() == 0 {
+			mode = 0644
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		files = append(files, target)
+		return nil
+	}
+	if isZip {
+		err = extractZip(path, add)
+	} else {
+		err = extractTarGz(path, add)
+	}
+	return files, err
+}
+
+func extractZip(path string, add func(string, os.FileMode, io.Reader) error) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err := add(f.Name, mode, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = add(f.Name, mode, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarGz(path string, add func(string, os.FileMode, io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = add(hdr.Name, mode, nil)
+		case tar.TypeReg:
+			err = add(hdr.Name, mode, tr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package download
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// zipFixture returns a zip archive of the entries, names to contents.
+func zipFixture(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tarGzFixture returns a gzipped tar archive of the entries.
+func tarGzFixture(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractArchives(t *testing.T) {
+	srv := serve(map[string][]byte{
+		"/good.zip":  zipFixture(t, map[string]string{"a.txt": "a", "sub/b.txt": "b"}),
+		"/t.tar.gz":  tarGzFixture(t, map[string]string{"d/x.txt": "x"}),
+		"/plain.txt": []byte("plain"),
+	})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, ExtractArchives: true})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/good.zip", srv.URL+"/t.tar.gz", srv.URL+"/plain.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{filepath.Join(dir, "good", "a.txt"), filepath.Join(dir, "good", "sub", "b.txt")},
+		{filepath.Join(dir, "t", "d", "x.txt")},
+		nil,
+	}
+	for i, r := range results {
+		got := append([]string(nil), r.Extracted...)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("Extracted of %s = %q, want %q", r.URL, got, want[i])
+		}
+	}
+	// The archives themselves are kept.
+	for _, name := range []string{"good.zip", "t.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "good", "sub", "b.txt")); string(b) != "b" {
+		t.Errorf("good/sub/b.txt = %q, want b", b)
+	}
+
+	// Without ExtractArchives nothing is extracted.
+	dir = t.TempDir()
+	results, err = NewDownloader(DownloadOptions{DownloadDir: dir}).DownloadAll(context.Background(), srv.URL+"/good.zip")
+	if err != nil || results[0].Extracted != nil {
+		t.Fatalf("DownloadAll() = %q, %v, want nothing extracted", results[0].Extracted, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "good")); !os.IsNotExist(err) {
+		t.Errorf("good was extracted without ExtractArchives: %v", err)
+	}
+}
+
+func TestExtractArchivesZipSlip(t *testing.T) {
+	tests := map[string][]byte{
+		"/parent.zip":    zipFixture(t, map[string]string{"ok.txt": "ok", "../evil.txt": "evil"}),
+		"/nested.zip":    zipFixture(t, map[string]string{"a/../../evil.txt": "evil"}),
+		"/absolute.zip":  zipFixture(t, map[string]string{"/evil.txt": "evil"}),
+		"/backslash.zip": zipFixture(t, map[string]string{`..\evil.txt`: "evil"}),
+		"/parent.tar.gz": tarGzFixture(t, map[string]string{"../evil.txt": "evil"}),
+	}
+	srv := serve(tests)
+	defer srv.Close()
+
+	for path := range tests {
+		t.Run(path[1:], func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "downloads")
+			d := NewDownloader(DownloadOptions{DownloadDir: dir, ExtractArchives: true})
+			results, err := d.DownloadAll(context.Background(), srv.URL+path)
+			if err != nil || results[0].Status != StatusDownloaded {
+				t.Fatalf("DownloadAll() = %v, %v, want the archive downloaded", results[0].Status, err)
+			}
+			if !errors.Is(results[0].ExtractErr, ErrInvalidFileName) {
+				t.Fatalf("ExtractErr = %v, want ErrInvalidFileName", results[0].ExtractErr)
+			}
+			for _, p := range []string{filepath.Join(dir, "evil.txt"), filepath.Join(filepath.Dir(dir), "evil.txt"), "/evil.txt"} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("an entry escaped to %s: %v", p, err)
+				}
+			}
+			// The partly extracted directory is removed again.
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("download dir has %d entries, want only the archive", len(entries))
+			}
+		})
+	}
+}
+
+func TestExtractArchivesFailure(t *testing.T) {
+	srv := serve(map[string][]byte{"/broken.zip": []byte("not a zip archive")})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var completed []DownloadResult
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, ExtractArchives: true, OnFileComplete: func(r DownloadResult) { completed = append(completed, r) }})
+	var events []EventType
+	var result DownloadResult
+	for ev := range d.DownloadStream(context.Background(), srv.URL+"/broken.zip") {
+		events = append(events, ev.Type)
+		if ev.Type == FileDone || ev.Type == Failed {
+			result = ev.Result
+		}
+	}
+	// The archive was downloaded, only its extraction failed.
+	if events[len(events)-1] != FileDone || result.Status != StatusDownloaded || result.Err != nil {
+		t.Fatalf("got the events %v and %+v, want the archive downloaded", events, result)
+	}
+	if result.ExtractErr == nil || result.Extracted != nil {
+		t.Errorf("ExtractErr = %v with %q extracted, want the error of the broken archive", result.ExtractErr, result.Extracted)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "broken.zip")); err != nil || string(got) != "not a zip archive" {
+		t.Errorf("broken.zip = %q, %v, want it kept", got, err)
+	}
+	if len(completed) != 1 || completed[0].ExtractErr == nil {
+		t.Errorf("OnFileComplete got %+v, want the result with its ExtractErr", completed)
+	}
+}
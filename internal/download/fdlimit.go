@@ -0,0 +1,116 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultFDRetryTimeout is how long running out of file descriptors is
+// waited out by default, see DownloadOptions.FDRetryTimeout.
+const defaultFDRetryTimeout = 30 * time.Second
+
+// tooManyOpenFiles reports whether err is the process or the system running
+// out of file descriptors.
+func tooManyOpenFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// fdLimiter lowers how many files a Downloader downloads at once after
+// running out of file descriptors. Every time they run out the limit is
+// halved, every finished file raises it by one again. Once no file is
+// running the limit is lifted.
+type fdLimiter struct {
+	mu      sync.Mutex
+	running int
+	// limit is the number of files which may run at once, 0 when there is
+	// no limit.
+	limit int
+	// changed is closed when a file finished.
+	changed chan struct{}
+}
+
+func newFDLimiter() *fdLimiter {
+	return &fdLimiter{changed: make(chan struct{})}
+}
+
+// acquire waits until another file may run or ctx is done.
+func (l *fdLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.limit == 0 || l.running < l.limit {
+			l.running++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release ends a file started by acquire.
+func (l *fdLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	switch {
+	case l.running == 0:
+		l.limit = 0
+	case l.limit > 0:
+		l.limit++
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// shrink halves the number of files running at once, down to one.
+func (l *fdLimiter) shrink() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := l.limit
+	if limit == 0 || limit > l.running {
+		limit = l.running
+	}
+	if limit /= 2; limit < 1 {
+		limit = 1
+	}
+	l.limit = limit
+	return limit
+}
+
+// retryOpenFiles calls fn until it doesn't fail with tooManyOpenFiles,
+// waiting with a growing backoff for other parts and files to release their
+// descriptors, until FDRetryTimeout passed. Every such failure lowers the
+// number of files downloaded at once.
+func (d *Downloader) retryOpenFiles(ctx context.Context, fn func() error) error {
+	timeout := d.downloadOptions.FDRetryTimeout
+	if timeout == 0 {
+		timeout = defaultFDRetryTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !tooManyOpenFiles(err) {
+			return err
+		}
+		limit := d.fds.shrink()
+		if timeout < 0 {
+			return err
+		}
+		wait := d.jitter(backoff(attempt))
+		if time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		d.printf("out of file descriptors, downloading at most %d files at once, retrying in %v: %v\n", limit, wait, err)
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// emfileStorage fails the first fails Creates with EMFILE.
+type emfileStorage struct {
+	*memStorage
+	fails int32
+}
+
+func (s *emfileStorage) Create(name string) (io.WriteCloser, error) {
+	if atomic.AddInt32(&s.fails, -1) >= 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EMFILE}
+	}
+	return s.memStorage.Create(name)
+}
+
+// fdStorage fails Creates with EMFILE while max of its files are open.
+type fdStorage struct {
+	*memStorage
+	max       int32
+	open      int32
+	exhausted int32
+}
+
+func (s *fdStorage) Create(name string) (io.WriteCloser, error) {
+	if atomic.AddInt32(&s.open, 1) > s.max {
+		atomic.AddInt32(&s.open, -1)
+		atomic.StoreInt32(&s.exhausted, 1)
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EMFILE}
+	}
+	w, err := s.memStorage.Create(name)
+	return fdFile{w, &s.open}, err
+}
+
+// fdFile gives its descriptor back when closed.
+type fdFile struct {
+	io.WriteCloser
+	open *int32
+}
+
+func (f fdFile) Close() error {
+	atomic.AddInt32(f.open, -1)
+	return f.WriteCloser.Close()
+}
+
+func TestTooManyOpenFiles(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{syscall.EMFILE, true},
+		{syscall.ENFILE, true},
+		{&os.PathError{Op: "open", Path: "f", Err: syscall.EMFILE}, true},
+		{fmt.Errorf("error while creating the temporary file: %w", syscall.ENFILE), true},
+		{syscall.ENOSPC, false},
+		{errors.New("too many open files"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := tooManyOpenFiles(tt.err); got != tt.want {
+			t.Errorf("tooManyOpenFiles(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryOpenFiles(t *testing.T) {
+	d := NewDownloader(DownloadOptions{})
+	d.jitter = func(time.Duration) time.Duration { return time.Millisecond }
+	var calls int
+	err := d.retryOpenFiles(context.Background(), func() error {
+		if calls++; calls < 3 {
+			return syscall.EMFILE
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retryOpenFiles() = %v after %d calls, want success on the third", err, calls)
+	}
+
+	// Other errors are not retried.
+	calls = 0
+	if err := d.retryOpenFiles(context.Background(), func() error { calls++; return syscall.ENOSPC }); !errors.Is(err, syscall.ENOSPC) || calls != 1 {
+		t.Errorf("retryOpenFiles() = %v after %d calls, want ENOSPC at once", err, calls)
+	}
+
+	// The wait stops with ctx.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d.jitter = func(time.Duration) time.Duration { return time.Hour }
+	if err := d.retryOpenFiles(ctx, func() error { return syscall.EMFILE }); err == nil {
+		t.Error("retryOpenFiles() after ctx was done succeeded")
+	}
+
+	// A wait beyond FDRetryTimeout gives up.
+	d = NewDownloader(DownloadOptions{FDRetryTimeout: 50 * time.Millisecond})
+	d.jitter = func(time.Duration) time.Duration { return time.Second }
+	start := time.Now()
+	if err := d.retryOpenFiles(context.Background(), func() error { return syscall.EMFILE }); !errors.Is(err, syscall.EMFILE) {
+		t.Errorf("retryOpenFiles() past FDRetryTimeout = %v, want EMFILE", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("retryOpenFiles() waited %v past FDRetryTimeout", elapsed)
+	}
+}
+
+func TestDownloadOutOfFileDescriptors(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := serve(map[string][]byte{"/a.bin": fixture(100), "/b.bin": data})
+	defer srv.Close()
+
+	// The output files can't be created at first, the batch waits.
+	storage := &emfileStorage{memStorage: newMemStorage(), fails: 3}
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 3})
+	d.jitter = func(time.Duration) time.Duration { return time.Millisecond }
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/a.bin", srv.URL+"/b.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.file("a.bin"), fixture(100)) || !bytes.Equal(storage.file("b.bin"), data) {
+		t.Error("downloaded files differ")
+	}
+
+	// A negative FDRetryTimeout fails right away.
+	storage = &emfileStorage{memStorage: newMemStorage(), fails: 1}
+	d = NewDownloader(DownloadOptions{Storage: storage, FDRetryTimeout: -1})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/a.bin"); !errors.Is(err, syscall.EMFILE) {
+		t.Errorf("DownloadAll() without FD retries error = %v, want EMFILE", err)
+	}
+}
+
+func TestFDLimiter(t *testing.T) {
+	l := newFDLimiter()
+	for i := 0; i < 8; i++ {
+		if err := l.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := l.shrink(); got != 4 {
+		t.Errorf("shrink() of 8 running files = %d, want 4", got)
+	}
+	if got := l.shrink(); got != 2 {
+		t.Errorf("shrink() again = %d, want 2", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx); err == nil {
+		t.Fatal("acquire() of a 9th file succeeded, want it to wait")
+	}
+
+	// A waiting file starts once fewer than the limit run, which grows
+	// with every finished file.
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.acquire(context.Background()) }()
+	for i := 0; i < 4; i++ {
+		l.release()
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire() still waits with 4 of 8 files finished")
+	}
+	for i := 0; i < 5; i++ {
+		l.release()
+	}
+	if l.running != 0 || l.limit != 0 {
+		t.Errorf("limiter has %d files running and limit %d, want it lifted once idle", l.running, l.limit)
+	}
+}
+
+func TestOutOfFileDescriptorsLowersConcurrency(t *testing.T) {
+	data := fixture(1000)
+	srv := newServer()
+	defer srv.Close()
+	srv.AddAll(data)
+	srv.Delay = 30 * time.Millisecond
+	var urls []string
+	for i := 0; i < 12; i++ {
+		urls = append(urls, fmt.Sprintf("%s/f%d.bin", srv.URL, i))
+	}
+
+	// Only 2 files can be open, 6 are downloaded at once at first.
+	storage := &fdStorage{memStorage: newMemStorage(), max: 2}
+	d := NewDownloader(DownloadOptions{Storage: storage, MaxLimitConcurrency: 6})
+	d.jitter = func(time.Duration) time.Duration { return time.Millisecond }
+	// The first 6 files run out of descriptors, those started after
+	// them are held back.
+	var started, running, peak int
+	for ev := range d.DownloadStream(context.Background(), urls...) {
+		switch ev.Type {
+		case Started:
+			started++
+			running++
+			if started > 6 && running > peak {
+				peak = running
+			}
+		case FileDone:
+			running--
+		case Failed:
+			t.Fatalf("download of %s failed: %v", ev.URL, ev.Result.Err)
+		}
+	}
+	if atomic.LoadInt32(&storage.exhausted) == 0 {
+		t.Fatal("the storage never ran out of file descriptors")
+	}
+	if peak >= 6 {
+		t.Errorf("%d files were downloaded at once after running out of file descriptors, want fewer than 6", peak)
+	}
+	for i := 0; i < 12; i++ {
+		if name := fmt.Sprintf("f%d.bin", i); !bytes.Equal(storage.file(name), data) {
+			t.Errorf("%s differs", name)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// ftpRetriever fetches a whole remote file from an FTP server.
+type ftpRetriever interface {
+	Retrieve(ctx context.Context, u *url.URL) (io.ReadCloser, error)
+}
+
+// jlaffayeRetriever is the default ftpRetriever backed by github.com/jlaffaye/ftp.
+// Credentials are taken from the url, falling back to anonymous login.
+type jlaffayeRetriever struct{}
+
+func (jlaffayeRetriever) Retrieve(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+	conn, err := ftp.Dial(addr, ftp.DialWithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error while connecting to ftp server %s: %w", addr, err)
+	}
+
+	user, password := "anonymous", "anonymous"
+	if u.User != nil {
+		user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			password = p
+		}
+	}
+	if err := conn.Login(user, password); err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("error while logging in to ftp server %s: %w", addr, err)
+	}
+
+	resp, err := conn.Retr(u.Path)
+	if err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("error while retrieving %s from ftp server %s: %w", u.Path, addr, err)
+	}
+	return &ftpResponse{Response: resp, conn: conn}, nil
+}
+
+// ftpResponse closes the control connection along with the data connection.
+// Close is safe to call more than once.
+type ftpResponse struct {
+	*ftp.Response
+	conn *ftp.ServerConn
+	once sync.Once
+	err  error
+}
+
+func (r *ftpResponse) Close() error {
+	r.once.Do(func() {
+		r.err = r.Response.Close()
+		r.conn.Quit()
+	})
+	return r.err
+}
+
+// downloadFTPFile downloads a file over FTP as a single stream, FTP servers
+// can't be relied upon to support ranged retrieval.
+func (d *Downloader) downloadFTPFile(ctx context.Context, req fileRequest, fileName string) (DownloadResult, error) {
+	fileUrl := req.url
+	u, err := url.Parse(fileUrl)
+	if err != nil {
+		return DownloadResult{}, fmt.Errorf("error while parsing url %s: %w", fileUrl, err)
+	}
+
+	fileHash, err := newHash(d.downloadOptions.ComputeHash)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	expected, wantSum, err := d.expectedHash(fileUrl)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	outFile, err := d.createOutputFile(ctx, req, fileName, false)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	outputFilePath := outFile.path
+	defer outFile.abort()
+
+	body, err := d.ftp.Retrieve(ctx, u)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	defer body.Close()
+
+	// Stop the transfer when ctx is cancelled, the ftp client only uses ctx
+	// while dialing.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			body.Close()
+		case <-done:
+		}
+	}()
+
+	var out io.Writer = outFile
+	for _, h := range []hash.Hash{fileHash, expected} {
+		if h != nil {
+			out = io.MultiWriter(out, h)
+		}
+	}
+	w, err := d.copyWithBuffer(budgetWriter(ctx, out), d.pause.reader(ctx, body, nil))
+	if err != nil {
+		return DownloadResult{}, fmt.Errorf("error while copying ftp file %s to file : %w", fileUrl, err)
+	}
+	d.printf("Wrote to File : %v, Written bytes : %v\n", outputFilePath, w)
+	if err := d.checkExpectedSize(fileUrl, w); err != nil {
+		return DownloadResult{}, err
+	}
+	if err := checkExpectedHash(outputFilePath, expected, wantSum); err != nil {
+		return DownloadResult{}, err
+	}
+
+	if err := outFile.commit(); err != nil {
+		return DownloadResult{}, err
+	}
+
+	return DownloadResult{Path: outputFilePath, Size: w, Concurrency: 1, Hash: hexSum(fileHash)}, nil
+}
@@ -0,0 +1,157 @@
+package download
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// newHash returns a hash for DownloadOptions.ComputeHash, or nil when name
+// is empty.
+func newHash(name string) (hash.Hash, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm %q", name)
+}
+
+// hexSum returns the hex digest of h, or "" for a nil h.
+func hexSum(h hash.Hash) string {
+	if h == nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// expectedHash returns a hash for the ExpectedHashes entry of fileUrl along
+// with the sum expected from it, or a nil hash when fileUrl has none.
+func (d *Downloader) expectedHash(fileUrl string) (hash.Hash, []byte, error) {
+	v, ok := d.downloadOptions.ExpectedHashes[fileUrl]
+	if !ok {
+		return nil, nil, nil
+	}
+	name, sum := "sha256", v
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		name, sum = v[:i], v[i+1:]
+	}
+	h, want, err := parseSum(name, sum)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while parsing the expected hash of %s: %w", fileUrl, err)
+	}
+	return h, want, nil
+}
+
+// parseSum returns a hash of the algorithm name and the decoded hex sum
+// expected from it.
+func parseSum(name, hexSum string) (hash.Hash, []byte, error) {
+	h, err := newHash(name)
+	if err == nil && h == nil {
+		err = fmt.Errorf("unsupported hash algorithm %q", name)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := hex.DecodeString(hexSum)
+	if err != nil || len(b) != h.Size() {
+		return nil, nil, fmt.Errorf("invalid %s sum %q", name, hexSum)
+	}
+	return h, b, nil
+}
+
+// VerifyFile checks the file at path against the hex digest expectedHex
+// computed with algo, one of md5, sha1, sha256 or sha512, e.g. to verify
+// downloaded files against a manifest later on. The file is streamed
+// through the hash, a different digest fails with ErrChecksumMismatch.
+func VerifyFile(path, algo, expectedHex string) error {
+	h, want, err := parseSum(algo, expectedHex)
+	if err != nil {
+		return fmt.Errorf("error while verifying %s: %w", path, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error while verifying %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("error while verifying %s: %w", path, err)
+	}
+	return checkExpectedHash(path, h, want)
+}
+
+// checkExpectedHash fails with ErrChecksumMismatch unless h, the hash of
+// the file at path, has the sum want. A nil h is not checked.
+func checkExpectedHash(path string, h hash.Hash, want []byte) error {
+	if h == nil {
+		return nil
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("%w for file %s: expected %x, got %x", ErrChecksumMismatch, path, want, got)
+	}
+	return nil
+}
+
+// digestAlgorithms maps the algorithms of the Digest header to newHash
+// names, the later ones in digestRank are preferred.
+var digestAlgorithms = map[string]string{
+	"md5":     "md5",
+	"sha":     "sha1",
+	"sha-256": "sha256",
+	"sha-512": "sha512",
+}
+
+var digestRank = []string{"md5", "sha1", "sha256", "sha512"}
+
+// serverDigest returns the strongest digest announced by the Digest or
+// Content-MD5 header, as a newHash name and the expected sum. name is ""
+// when header has no digest of a supported algorithm.
+func serverDigest(header http.Header) (name string, sum []byte) {
+	rank := func(name string) int {
+		for i, n := range digestRank {
+			if n == name {
+				return i
+			}
+		}
+		return -1
+	}
+	offer := func(algorithm, value string) {
+		if algorithm == "" || name != "" && rank(algorithm) <= rank(name) {
+			return
+		}
+		b, err := base64.StdEncoding.DecodeString(value)
+		if h, _ := newHash(algorithm); err != nil || len(b) != h.Size() {
+			return
+		}
+		name, sum = algorithm, b
+	}
+	for _, v := range header.Values("Digest") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if i := strings.IndexByte(d, '='); i > 0 {
+				offer(digestAlgorithms[strings.ToLower(d[:i])], d[i+1:])
+			}
+		}
+	}
+	if v := header.Get("Content-MD5"); v != "" {
+		offer("md5", strings.TrimSpace(v))
+	}
+	return name, sum
+}
@@ -0,0 +1,290 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anupam111/concurrent-downloader/internal/download/internal/testserver"
+)
+
+// corruptServer serves data with the byte at offset flipped in the body of
+// every GET.
+func corruptServer(data []byte, offset int) *testserver.FileServer {
+	corrupt := append([]byte(nil), data...)
+	corrupt[offset] ^= 0xff
+	s := newServer()
+	s.AddAll(corrupt)
+	return s
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestExpectedHashesCatchCorruptPart(t *testing.T) {
+	data := fixture(12 << 20)
+	// The byte is in the third of 4 parts.
+	srv := corruptServer(data, 7<<20)
+	defer srv.Close()
+	url := srv.URL + "/f.bin"
+
+	storages := map[string]func(t *testing.T) DownloadOptions{
+		"in place": func(t *testing.T) DownloadOptions { return DownloadOptions{DownloadDir: t.TempDir()} },
+		"staged": func(t *testing.T) DownloadOptions {
+			return DownloadOptions{Storage: newMemStorage(), TempDir: t.TempDir()}
+		},
+	}
+	for name, opts := range storages {
+		t.Run(name, func(t *testing.T) {
+			o := opts(t)
+			o.NumConcParts = 4
+			o.ExpectedHashes = map[string]string{url: "sha256:" + sha256Hex(data)}
+			_, err := NewDownloader(o).DownloadAll(context.Background(), url)
+			if !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("DownloadAll() error = %v, want ErrChecksumMismatch", err)
+			}
+		})
+	}
+}
+
+func TestExpectedHashes(t *testing.T) {
+	small, large := fixture(1000), fixture(12<<20)
+	srv := serve(map[string][]byte{"/small.bin": small, "/large.bin": large})
+	defer srv.Close()
+
+	hashes := map[string]string{
+		srv.URL + "/small.bin": sha256Hex(small),
+		srv.URL + "/large.bin": "SHA256:" + sha256Hex(large),
+	}
+	for _, storage := range []Storage{LocalStorage{Dir: t.TempDir()}, newMemStorage()} {
+		d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 3, ExpectedHashes: hashes, ComputeHash: "sha256"})
+		results, err := d.DownloadAll(context.Background(), srv.URL+"/small.bin", srv.URL+"/large.bin")
+		if err != nil {
+			t.Fatalf("DownloadAll() with %T: %v", storage, err)
+		}
+		for i, want := range [][]byte{small, large} {
+			if results[i].Hash != sha256Hex(want) {
+				t.Errorf("Hash of %s with %T = %s, want %s", results[i].URL, storage, results[i].Hash, sha256Hex(want))
+			}
+		}
+	}
+
+	d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), ExpectedHashes: map[string]string{srv.URL + "/small.bin": "crc32:00"}})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/small.bin"); err == nil {
+		t.Error("DownloadAll() with an unsupported algorithm succeeded")
+	}
+}
+
+// writerAtStorage keeps files in memory behind writers which can be
+// written at offsets but not read back.
+type writerAtStorage struct {
+	*memStorage
+}
+
+type writerAtFile struct {
+	mu  sync.Mutex
+	buf *bytes.Buffer
+}
+
+func (f *writerAtFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buf.Write(p)
+}
+
+func (f *writerAtFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := int(off) + len(p); end > f.buf.Len() {
+		f.buf.Write(make([]byte, end-f.buf.Len()))
+	}
+	return copy(f.buf.Bytes()[off:], p), nil
+}
+
+func (f *writerAtFile) Close() error { return nil }
+
+func (s writerAtStorage) Create(name string) (io.WriteCloser, error) {
+	w, _ := s.memStorage.Create(name)
+	return &writerAtFile{buf: w.(nopCloser).Writer.(*bytes.Buffer)}, nil
+}
+
+func TestSinglePartHashedInline(t *testing.T) {
+	data := fixture(5000)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	// The storage can't be read back, so the file must be hashed while it
+	// is written.
+	storage := writerAtStorage{newMemStorage()}
+	d := NewDownloader(DownloadOptions{Storage: storage, ComputeHash: "sha256"})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Hash != sha256Hex(data) {
+		t.Errorf("Hash = %s, want %s", results[0].Hash, sha256Hex(data))
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("downloaded file differs")
+	}
+}
+
+func TestComputeHash(t *testing.T) {
+	large, small := fixture(12<<20), fixture(1000)
+	srv := serve(map[string][]byte{"/large.bin": large, "/small.bin": small, "/abc": []byte("abc")})
+	defer srv.Close()
+
+	sums := func(b []byte) map[string]string {
+		m, s1, s256, s512 := md5.Sum(b), sha1.Sum(b), sha256.Sum256(b), sha512.Sum512(b)
+		return map[string]string{"md5": hex.EncodeToString(m[:]), "sha1": hex.EncodeToString(s1[:]), "sha256": hex.EncodeToString(s256[:]), "sha512": hex.EncodeToString(s512[:])}
+	}
+	want := map[string]map[string]string{"large.bin": sums(large), "small.bin": sums(small), "abc": sums([]byte("abc"))}
+	if want["abc"]["sha256"] != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Fatalf("sha256 of abc = %s", want["abc"]["sha256"])
+	}
+
+	for _, algo := range []string{"md5", "sha1", "sha256", "SHA512"} {
+		// Large local files are read back, the parts for memStorage are
+		// hashed while they are combined.
+		for _, opts := range []DownloadOptions{{DownloadDir: t.TempDir()}, {Storage: newMemStorage(), TempDir: t.TempDir()}} {
+			opts.NumConcParts, opts.ComputeHash = 3, algo
+			results, err := NewDownloader(opts).DownloadAll(context.Background(), srv.URL+"/large.bin", srv.URL+"/small.bin", srv.URL+"/abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range results {
+				name := r.URL[strings.LastIndex(r.URL, "/")+1:]
+				if r.Hash != want[name][strings.ToLower(algo)] {
+					t.Errorf("%s Hash of %s = %s, want %s", algo, name, r.Hash, want[name][strings.ToLower(algo)])
+				}
+			}
+		}
+	}
+
+	results, err := NewDownloader(DownloadOptions{DownloadDir: t.TempDir()}).DownloadAll(context.Background(), srv.URL+"/small.bin")
+	if err != nil || results[0].Hash != "" {
+		t.Errorf("Hash without ComputeHash = %q, %v, want none", results[0].Hash, err)
+	}
+	if _, err := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), ComputeHash: "crc32"}).Download(srv.URL + "/small.bin"); err == nil || !strings.Contains(err.Error(), "crc32") {
+		t.Errorf("Download() with ComputeHash crc32 error = %v, want an unsupported algorithm", err)
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.bin")
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const abc = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if err := VerifyFile(path, "sha256", abc); err != nil {
+		t.Errorf("VerifyFile() = %v", err)
+	}
+	if err := VerifyFile(path, "SHA256", strings.ToUpper(abc)); err != nil {
+		t.Errorf("VerifyFile() in upper case = %v", err)
+	}
+	if err := VerifyFile(path, "md5", strings.Repeat("0", 32)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("VerifyFile() of a different md5 = %v, want ErrChecksumMismatch", err)
+	}
+	if err := VerifyFile(path+".missing", "sha256", abc); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("VerifyFile() of a missing file = %v, want os.ErrNotExist", err)
+	}
+	for _, tt := range []struct{ algo, sum string }{{"crc32", "00"}, {"md5", "zz"}, {"sha256", abc[:10]}} {
+		if err := VerifyFile(path, tt.algo, tt.sum); err == nil || errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("VerifyFile() with %s %q = %v, want an invalid sum", tt.algo, tt.sum, err)
+		}
+	}
+}
+
+func TestServerDigest(t *testing.T) {
+	data := []byte("abc")
+	m, s256 := md5.Sum(data), sha256.Sum256(data)
+	md5b64, sha256b64 := base64.StdEncoding.EncodeToString(m[:]), base64.StdEncoding.EncodeToString(s256[:])
+	tests := []struct {
+		header   http.Header
+		wantName string
+		want     []byte
+	}{
+		{http.Header{"Content-Md5": {md5b64}}, "md5", m[:]},
+		{http.Header{"Digest": {"SHA-256=" + sha256b64}}, "sha256", s256[:]},
+		// The strongest algorithm wins, whatever its position.
+		{http.Header{"Digest": {"sha-256=" + sha256b64 + ", MD5=" + md5b64}}, "sha256", s256[:]},
+		{http.Header{"Digest": {"MD5=" + md5b64}, "Content-Md5": {md5b64}}, "md5", m[:]},
+		{http.Header{"Content-Md5": {md5b64}, "Digest": {"SHA-256=" + sha256b64}}, "sha256", s256[:]},
+		// Unsupported, malformed or wrongly sized digests are ignored.
+		{http.Header{"Digest": {"crc32c=AAAAAA=="}}, "", nil},
+		{http.Header{"Digest": {"SHA-256=not base64"}}, "", nil},
+		{http.Header{"Digest": {"SHA-256=" + md5b64}}, "", nil},
+		{http.Header{}, "", nil},
+	}
+	for _, tt := range tests {
+		name, sum := serverDigest(tt.header)
+		if name != tt.wantName || !bytes.Equal(sum, tt.want) {
+			t.Errorf("serverDigest(%v) = %q, %x, want %q, %x", tt.header, name, sum, tt.wantName, tt.want)
+		}
+	}
+}
+
+func TestVerifyServerDigest(t *testing.T) {
+	data := fixture(12 << 20)
+	m, s256 := md5.Sum(data), sha256.Sum256(data)
+	zero := func(n int) string { return base64.StdEncoding.EncodeToString(make([]byte, n)) }
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		header http.Header
+		ok     bool
+	}{
+		{"md5", http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(m[:])}}, true},
+		{"wrong md5", http.Header{"Content-Md5": {zero(16)}}, false},
+		// A wrong weaker digest is not checked.
+		{"sha256", http.Header{"Digest": {"MD5=" + zero(16) + ", SHA-256=" + base64.StdEncoding.EncodeToString(s256[:])}}, true},
+		{"wrong sha256", http.Header{"Digest": {"SHA-256=" + zero(32)}}, false},
+		{"none", http.Header{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header = tt.header
+			for _, parts := range []int{1, 3} {
+				dir := t.TempDir()
+				d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: parts, VerifyServerDigest: true})
+				_, err := d.Download(srv.URL + "/f.bin")
+				if tt.ok && err != nil || !tt.ok && !errors.Is(err, ErrChecksumMismatch) {
+					t.Fatalf("Download() in %d parts error = %v, want ok: %v", parts, err, tt.ok)
+				}
+				if entries, _ := os.ReadDir(dir); !tt.ok && len(entries) != 0 {
+					t.Errorf("the mismatching download left %d files behind", len(entries))
+				}
+			}
+		})
+	}
+
+	// Without VerifyServerDigest the header is ignored.
+	header = http.Header{"Content-Md5": {zero(16)}}
+	if _, err := NewDownloader(DownloadOptions{DownloadDir: t.TempDir()}).Download(srv.URL + "/f.bin"); err != nil {
+		t.Errorf("Download() without VerifyServerDigest error = %v", err)
+	}
+}
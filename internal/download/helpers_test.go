@@ -0,0 +1,93 @@
+package download
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/anupam111/concurrent-downloader/internal/download/internal/testserver"
+)
+
+// fixedModTime is the modification time of the files of serve.
+var fixedModTime = time.Unix(1600000000, 0)
+
+// fixture returns n pseudo-random bytes, the same for the same n.
+func fixture(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+// newServer starts a FileServer of files in memory modified at
+// fixedModTime. The caller must Close it.
+func newServer() *testserver.FileServer {
+	s := testserver.NewFileServer("")
+	s.ModTime = fixedModTime
+	return s
+}
+
+// serve serves the files by path, with range support.
+func serve(files map[string][]byte) *testserver.FileServer {
+	s := newServer()
+	for name, b := range files {
+		s.Add(name, b)
+	}
+	return s
+}
+
+// memStorage is a Storage keeping the files in memory. Its writers can't
+// be written at offsets, so parts are staged and combined.
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string]*bytes.Buffer
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[string]*bytes.Buffer)}
+}
+
+func (m *memStorage) Create(name string) (io.WriteCloser, error) {
+	b := &bytes.Buffer{}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = b
+	return nopCloser{b}, nil
+}
+
+func (m *memStorage) Exists(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.files[name]
+	return ok
+}
+
+func (m *memStorage) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[newName] = m.files[oldName]
+	delete(m.files, oldName)
+	return nil
+}
+
+func (m *memStorage) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+// file returns the content of the named file, nil when it doesn't exist.
+func (m *memStorage) file(name string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.files[name]; ok {
+		return b.Bytes()
+	}
+	return nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
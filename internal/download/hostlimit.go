@@ -0,0 +1,62 @@
+package download
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// hostLimiter is a RoundTripper allowing at most max requests per host at
+// once, see DownloadOptions.MaxConnsPerHost. A slot is held until the body
+// of the response is closed.
+type hostLimiter struct {
+	next http.RoundTripper
+	max  int64
+
+	mu    sync.Mutex
+	hosts map[string]*semaphore.Weighted
+}
+
+func newHostLimiter(next http.RoundTripper, max int) *hostLimiter {
+	return &hostLimiter{next: next, max: int64(max), hosts: make(map[string]*semaphore.Weighted)}
+}
+
+func (l *hostLimiter) host(host string) *semaphore.Weighted {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sem, ok := l.hosts[host]
+	if !ok {
+		sem = semaphore.NewWeighted(l.max)
+		l.hosts[host] = sem
+	}
+	return sem
+}
+
+func (l *hostLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	sem := l.host(req.URL.Host)
+	if err := sem.Acquire(req.Context(), 1); err != nil {
+		return nil, err
+	}
+	resp, err := l.next.RoundTrip(req)
+	if err != nil {
+		sem.Release(1)
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { sem.Release(1) }}
+	return resp, nil
+}
+
+// releaseBody calls release once it is closed for the first time.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
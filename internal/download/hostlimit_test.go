@@ -0,0 +1,114 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anupam111/concurrent-downloader/internal/download/internal/testserver"
+)
+
+// newPeakServer serves data for every path slowly enough for concurrent
+// requests to overlap, its Peak is how many it handled at once.
+func newPeakServer(data []byte) *testserver.FileServer {
+	s := newServer()
+	s.AddAll(data)
+	s.Delay = 20 * time.Millisecond
+	return s
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	data := fixture(12 << 20)
+	a, b := newPeakServer(data), newPeakServer(data)
+	defer a.Close()
+	defer b.Close()
+
+	// 6 files of 4 parts could send 24 requests at once.
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage(), TempDir: t.TempDir(), NumConcParts: 4, MaxLimitConcurrency: 6, MaxConnsPerHost: 2})
+	var urls []string
+	for i := 0; i < 3; i++ {
+		urls = append(urls, a.URL+"/a"+strconv.Itoa(i), b.URL+"/b"+strconv.Itoa(i))
+	}
+	if _, err := d.DownloadAll(context.Background(), urls...); err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]*testserver.FileServer{"a": a, "b": b} {
+		if peak := s.Peak(); peak != 2 {
+			t.Errorf("host %s handled %d requests at once, want the limit of 2", name, peak)
+		}
+	}
+}
+
+func TestHostLimiterReleasesOnClose(t *testing.T) {
+	srv := serve(map[string][]byte{"/f": fixture(100)})
+	defer srv.Close()
+	client := &http.Client{Transport: newHostLimiter(http.DefaultTransport, 1)}
+
+	resp, err := client.Get(srv.URL + "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The only slot is held until the body is closed.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/f", nil)
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("second request got a slot while the first body is open")
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	// Closing twice releases the slot once.
+	resp.Body.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL + "/f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	// A failed request gives its slot back too.
+	if _, err := client.Get("http://127.0.0.1:1/f"); err == nil {
+		t.Fatal("request to a closed port succeeded")
+	}
+	resp, err = client.Get(srv.URL + "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestHostLimiterProbeFallback(t *testing.T) {
+	data := fixture(12 << 20)
+	noHead := noHeadServer(data)
+	defer noHead.Close()
+	// HEAD advertises ranges without a size.
+	chunked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer chunked.Close()
+
+	// The range probe of either server needs the slot of the HEAD request.
+	for _, url := range []string{noHead.URL + "/f.bin", chunked.URL + "/f.bin"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		storage := newMemStorage()
+		d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 3, MaxConnsPerHost: 1})
+		results, err := d.DownloadAll(ctx, url)
+		cancel()
+		if err != nil {
+			t.Fatalf("DownloadAll() of %s with MaxConnsPerHost 1 = %v", url, err)
+		}
+		if results[0].Concurrency != 3 || !bytes.Equal(storage.file("f.bin"), data) {
+			t.Errorf("downloaded %d bytes of %s in %d parts, want the file in 3 parts", len(storage.file("f.bin")), url, results[0].Concurrency)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package download
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadURLs reads one url per line from r. Blank lines and lines starting
+// with "#" are skipped, surrounding whitespace is trimmed.
+func ReadURLs(r io.Reader) ([]string, error) {
+	var fileUrls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fileUrls = append(fileUrls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading urls: %w", err)
+	}
+	return fileUrls, nil
+}
+
+// DownloadFromReader downloads all the urls read from r, see ReadURLs for
+// the expected format.
+func (d *Downloader) DownloadFromReader(r io.Reader) (downloadPaths []string, err error) {
+	fileUrls, err := ReadURLs(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.Download(fileUrls...)
+}
@@ -0,0 +1,59 @@
+package download
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadURLs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"http://example.com/a", []string{"http://example.com/a"}},
+		{"http://example.com/a\nhttp://example.com/b\n", []string{"http://example.com/a", "http://example.com/b"}},
+		{"\n\n  \t\nhttp://example.com/a\n\n", []string{"http://example.com/a"}},
+		{"  http://example.com/a  \r\n\thttp://example.com/b\r\n", []string{"http://example.com/a", "http://example.com/b"}},
+		{"# mirrors\nhttp://example.com/a\n  # indented comment\n#http://example.com/b", []string{"http://example.com/a"}},
+		{"http://example.com/a#fragment", []string{"http://example.com/a#fragment"}},
+	}
+	for _, tt := range tests {
+		got, err := ReadURLs(strings.NewReader(tt.input))
+		if err != nil {
+			t.Errorf("ReadURLs(%q) error = %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ReadURLs(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestReadURLsError(t *testing.T) {
+	if _, err := ReadURLs(errReader{}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("ReadURLs() error = %v, want the read error", err)
+	}
+}
+
+func TestDownloadFromReader(t *testing.T) {
+	srv := serve(map[string][]byte{"/a.bin": fixture(10), "/b.bin": fixture(20)})
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage})
+	paths, err := d.DownloadFromReader(strings.NewReader("# files\n" + srv.URL + "/a.bin\n\n  " + srv.URL + "/b.bin  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(paths, []string{"a.bin", "b.bin"}) {
+		t.Errorf("DownloadFromReader() = %q, want a.bin and b.bin", paths)
+	}
+}
@@ -0,0 +1,262 @@
+// Package testserver provides the http server the tests of the download
+// package and its users download from. It only imports the standard
+// library, so the internal tests of download can use it too.
+package testserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileServer is a real http server of the files in Dir and of files added
+// in memory, for integration testing the downloader. Files are served with
+// http.ServeContent, so Range requests, Last-Modified and conditional
+// requests behave like a real server. Files on disk also get an ETag of
+// their size and modification time. Failures can be injected per file or
+// per request. It is safe for concurrent use.
+type FileServer struct {
+	*httptest.Server
+	// Dir holds the files on disk, none when empty.
+	Dir string
+	// ModTime is the modification time of the files in memory.
+	ModTime time.Time
+	// Delay is waited before each request is served.
+	Delay time.Duration
+	// FailFunc, unless nil, returns the failure of requests without an
+	// injected one, nil to serve them. Set it before the first request.
+	FailFunc func(r *http.Request) *Failure
+
+	mu       sync.Mutex
+	files    map[string][]byte
+	all      *[]byte
+	failures map[string][]Failure
+	requests map[string][]*http.Request
+	ranges   []string
+	running  int
+	peak     int
+}
+
+// Failure is a failure injected into a request for a file of a FileServer.
+type Failure struct {
+	// Status is written in place of the file unless zero.
+	Status int
+	// After is how many bytes of the file are written before the
+	// connection is closed, when Status is zero.
+	After int64
+	// Stall is how long the connection stays open without sending more
+	// after After bytes, before it is closed.
+	Stall time.Duration
+	// Wait, unless nil, holds the failure back until it is closed.
+	Wait <-chan struct{}
+}
+
+// NewFileServer starts a FileServer of the files in dir. The caller must
+// Close it.
+func NewFileServer(dir string) *FileServer {
+	s := &FileServer{
+		Dir:      dir,
+		files:    make(map[string][]byte),
+		failures: make(map[string][]Failure),
+		requests: make(map[string][]*http.Request),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Fixture writes a file of size pseudo random bytes named name into Dir.
+// It returns the url of the file and its content.
+func (s *FileServer) Fixture(name string, size int64) (string, []byte, error) {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(size)).Read(content)
+	filePath := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", nil, fmt.Errorf("error while creating fixture directory: %w", err)
+	}
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		return "", nil, fmt.Errorf("error while writing fixture %s: %w", filePath, err)
+	}
+	return s.URL + "/" + strings.TrimPrefix(name, "/"), content, nil
+}
+
+// Add serves content as the file name from memory and returns its url.
+func (s *FileServer) Add(name string, content []byte) string {
+	name = cleanName(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = content
+	return s.URL + name
+}
+
+// AddAll serves content for every name without a file of its own.
+func (s *FileServer) AddAll(content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.all = &content
+}
+
+// Fail injects failures into the next requests for name, one per request
+// in order.
+func (s *FileServer) Fail(name string, failures ...Failure) {
+	name = cleanName(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[name] = append(s.failures[name], failures...)
+}
+
+// Requests returns the requests for name served so far, in order.
+func (s *FileServer) Requests(name string) []*http.Request {
+	name = cleanName(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests[name]...)
+}
+
+// Ranges returns the Range headers of the GET requests for any file served
+// so far, in order. GETs without a Range header add an empty string.
+func (s *FileServer) Ranges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+// Reset forgets the requests served so far.
+func (s *FileServer) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = make(map[string][]*http.Request)
+	s.ranges = nil
+}
+
+// Peak returns the most requests the server handled at once.
+func (s *FileServer) Peak() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peak
+}
+
+func cleanName(name string) string {
+	return path.Clean("/" + name)
+}
+
+func (s *FileServer) serve(w http.ResponseWriter, r *http.Request) {
+	name := cleanName(r.URL.Path)
+	s.mu.Lock()
+	s.requests[name] = append(s.requests[name], r)
+	if r.Method == http.MethodGet {
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+	}
+	if s.running++; s.running > s.peak {
+		s.peak = s.running
+	}
+	var failure *Failure
+	if f := s.failures[name]; len(f) > 0 {
+		failure = &f[0]
+		s.failures[name] = f[1:]
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+	}()
+
+	if failure == nil && s.FailFunc != nil {
+		failure = s.FailFunc(r)
+	}
+	if s.Delay > 0 {
+		time.Sleep(s.Delay)
+	}
+	if failure != nil && failure.Wait != nil {
+		select {
+		case <-failure.Wait:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if failure != nil && failure.Status != 0 {
+		http.Error(w, http.StatusText(failure.Status), failure.Status)
+		return
+	}
+
+	content, modTime, ok := s.open(w, name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if c, ok := content.(io.Closer); ok {
+		defer c.Close()
+	}
+	if failure != nil {
+		w = &cutWriter{ResponseWriter: w, left: failure.After, stall: failure.Stall, done: r.Context().Done()}
+	}
+	http.ServeContent(w, r, path.Base(name), modTime, content)
+}
+
+// open returns the file name from memory or from Dir and sets its ETag
+// on w.
+func (s *FileServer) open(w http.ResponseWriter, name string) (io.ReadSeeker, time.Time, bool) {
+	s.mu.Lock()
+	content, ok := s.files[name]
+	if !ok && s.all != nil {
+		content, ok = *s.all, true
+	}
+	s.mu.Unlock()
+	if ok {
+		return bytes.NewReader(content), s.ModTime, true
+	}
+	if s.Dir == "" {
+		return nil, time.Time{}, false
+	}
+
+	f, err := os.Open(filepath.Join(s.Dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		return nil, time.Time{}, false
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+	return f, fi.ModTime(), true
+}
+
+// cutWriter panics with http.ErrAbortHandler once left bytes are written,
+// which makes the server close the connection mid body. It stalls for
+// stall before, unless the request is done first.
+type cutWriter struct {
+	http.ResponseWriter
+	left  int64
+	stall time.Duration
+	done  <-chan struct{}
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.left {
+		n, _ := w.ResponseWriter.Write(p[:w.left])
+		w.left -= int64(n)
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		if w.stall > 0 {
+			select {
+			case <-time.After(w.stall):
+			case <-w.done:
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.left -= int64(n)
+	return n, err
+}
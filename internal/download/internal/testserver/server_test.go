@@ -0,0 +1,129 @@
+package testserver
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, url, rangeHeader string) (*http.Response, []byte, error) {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+func TestFileServer(t *testing.T) {
+	s := NewFileServer(t.TempDir())
+	defer s.Close()
+	url, content, err := s.Fixture("dir/f.bin", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body, err := get(t, url, "bytes=100-199")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, content[100:200]) {
+		t.Errorf("range request got %d with %d bytes, want 206 with bytes 100-199", resp.StatusCode, len(body))
+	}
+	if resp.Header.Get("ETag") == "" || resp.Header.Get("Last-Modified") == "" {
+		t.Errorf("response has no validators: %v", resp.Header)
+	}
+	if got := len(s.Requests("dir/f.bin")); got != 1 {
+		t.Errorf("Requests() has %d requests, want 1", got)
+	}
+
+	if _, body, _ := get(t, s.URL+"/missing.bin", ""); !bytes.Contains(body, []byte("not found")) {
+		t.Errorf("missing file got %q, want a 404", body)
+	}
+}
+
+func TestFileServerFailures(t *testing.T) {
+	s := NewFileServer(t.TempDir())
+	defer s.Close()
+	url, content, err := s.Fixture("f.bin", 100<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Fail("f.bin", Failure{Status: http.StatusServiceUnavailable}, Failure{After: 1000})
+
+	resp, _, err := get(t, url, "")
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("first request got %v, %v, want 503", resp, err)
+	}
+	if _, body, err := get(t, url, ""); err == nil || len(body) != 1000 {
+		t.Fatalf("second request got %d bytes and %v, want a cut after 1000 bytes", len(body), err)
+	}
+	if _, body, err := get(t, url, ""); err != nil || !bytes.Equal(body, content) {
+		t.Fatalf("third request got %d bytes and %v, want the file", len(body), err)
+	}
+}
+
+func TestFileServerInMemory(t *testing.T) {
+	s := NewFileServer("")
+	defer s.Close()
+	s.ModTime = time.Unix(1600000000, 0)
+	release := make(chan struct{})
+	s.FailFunc = func(r *http.Request) *Failure {
+		if r.Header.Get("Range") == "bytes=5-" {
+			return &Failure{Status: http.StatusInternalServerError, Wait: release}
+		}
+		return nil
+	}
+	url := s.Add("f.bin", []byte("0123456789"))
+	s.AddAll([]byte("any"))
+
+	if _, body, err := get(t, url, "bytes=2-4"); err != nil || string(body) != "234" {
+		t.Errorf("range request got %q and %v, want 234", body, err)
+	}
+	if _, body, err := get(t, s.URL+"/other.bin", ""); err != nil || string(body) != "any" {
+		t.Errorf("request of another file got %q and %v, want the content of AddAll", body, err)
+	}
+	close(release)
+	if resp, _, err := get(t, url, "bytes=5-"); err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("failing request got %v, %v, want 500", resp, err)
+	}
+	if got, want := s.Ranges(), []string{"bytes=2-4", "", "bytes=5-"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Ranges() = %q, want %q", got, want)
+	}
+	if s.Peak() != 1 {
+		t.Errorf("Peak() = %d, want 1", s.Peak())
+	}
+	s.Reset()
+	if got := s.Ranges(); len(got) != 0 {
+		t.Errorf("Ranges() after Reset() = %q", got)
+	}
+}
+
+func TestFileServerStall(t *testing.T) {
+	s := NewFileServer("")
+	defer s.Close()
+	url := s.Add("f.bin", []byte("0123456789"))
+	const stall = 200 * time.Millisecond
+	s.Fail("f.bin", Failure{After: 4, Stall: stall})
+
+	start := time.Now()
+	_, body, err := get(t, url, "")
+	if err == nil || string(body) != "0123" {
+		t.Errorf("stalled request got %q and %v, want a cut after 4 bytes", body, err)
+	}
+	if elapsed := time.Since(start); elapsed < stall {
+		t.Errorf("stalled request took %v, want at least %v", elapsed, stall)
+	}
+}
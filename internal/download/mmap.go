@@ -0,0 +1,24 @@
+package download
+
+import (
+	"fmt"
+	"io"
+)
+
+// mappedFile writes the parts of a file into its memory mapping.
+type mappedFile struct {
+	data []byte
+}
+
+// WriteAt copies p into the mapping at off. Writes beyond the mapped size
+// fail.
+func (m *mappedFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off > int64(len(m.data)) {
+		return 0, fmt.Errorf("error while writing at offset %d of a %d byte mapping: %w", off, len(m.data), io.ErrShortWrite)
+	}
+	n := copy(m.data[off:], p)
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
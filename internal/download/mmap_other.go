@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package download
+
+import "os"
+
+// mmapFile returns nil, files are only mapped on Unix systems.
+func mmapFile(f *os.File, size int64) *mappedFile {
+	return nil
+}
+
+func (m *mappedFile) unmap() error {
+	return nil
+}
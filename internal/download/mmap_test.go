@@ -0,0 +1,60 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmapDownload(t *testing.T) {
+	data := fixture(23<<20 + 17)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+
+	for _, opts := range []DownloadOptions{
+		{NumConcParts: 4, UseMmap: true},
+		{NumConcParts: 4, UseMmap: true, WorkStealing: true, ComputeHash: "sha256"},
+		{NumConcParts: 4, UseMmap: true, Resume: true},
+		// Storage other than the local disk can't be mapped.
+		{NumConcParts: 4, UseMmap: true, Storage: newMemStorage()},
+	} {
+		dir := t.TempDir()
+		opts.DownloadDir, opts.TempDir = dir, t.TempDir()
+		results, err := NewDownloader(opts).DownloadAll(context.Background(), srv.URL+"/f.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := os.ReadFile(filepath.Join(dir, "f.bin"))
+		if m, ok := opts.Storage.(*memStorage); ok {
+			got = m.file("f.bin")
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("the mapped download with %+v differs", opts)
+		}
+		if opts.ComputeHash != "" && results[0].Hash != sha256Hex(data) {
+			t.Errorf("Hash = %q, want %q", results[0].Hash, sha256Hex(data))
+		}
+	}
+}
+
+func TestMappedFileWriteAt(t *testing.T) {
+	m := &mappedFile{data: make([]byte, 10)}
+	if n, err := m.WriteAt([]byte("abc"), 7); n != 3 || err != nil {
+		t.Errorf("WriteAt() at the end = %d, %v", n, err)
+	}
+	if n, err := m.WriteAt([]byte("abcd"), 8); n != 2 || !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("WriteAt() past the end = %d, %v, want 2 and io.ErrShortWrite", n, err)
+	}
+	for _, off := range []int64{-1, 11} {
+		if n, err := m.WriteAt([]byte("a"), off); n != 0 || !errors.Is(err, io.ErrShortWrite) {
+			t.Errorf("WriteAt() at %d = %d, %v, want io.ErrShortWrite", off, n, err)
+		}
+	}
+	if want := "\x00\x00\x00\x00\x00\x00\x00aab"; string(m.data) != want {
+		t.Errorf("mapping = %q, want %q", m.data, want)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package download
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of f for writing, see
+// DownloadOptions.UseMmap. It returns nil when f can't be mapped, the
+// parts then write with WriteAt.
+func mmapFile(f *os.File, size int64) *mappedFile {
+	if size <= 0 || size > math.MaxInt {
+		return nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil
+	}
+	return &mappedFile{data: data}
+}
+
+func (m *mappedFile) unmap() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	if err := syscall.Munmap(data); err != nil {
+		return fmt.Errorf("error while unmapping output file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmapFile(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(4096); err != nil {
+		t.Fatal(err)
+	}
+	if mmapFile(f, 0) != nil {
+		t.Error("mmapFile() mapped an empty file")
+	}
+
+	m := mmapFile(f, 4096)
+	if m == nil {
+		t.Fatal("mmapFile() = nil")
+	}
+	if _, err := m.WriteAt([]byte("mapped"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.unmap(); err != nil {
+		t.Fatal(err)
+	}
+	// A second unmap is a no-op.
+	if err := m.unmap(); err != nil {
+		t.Errorf("second unmap() = %v", err)
+	}
+	got, _ := os.ReadFile(f.Name())
+	if string(got[100:106]) != "mapped" {
+		t.Errorf("file holds %q at 100, want the mapped write", got[100:106])
+	}
+}
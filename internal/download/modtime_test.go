@@ -0,0 +1,112 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPreserveModTime(t *testing.T) {
+	srv := serve(map[string][]byte{"/small.bin": fixture(1000), "/large.bin": fixture(12 << 20)})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 3, PreserveModTime: true})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/small.bin", srv.URL+"/large.bin"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"small.bin", "large.bin"} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(fixedModTime) {
+			t.Errorf("modification time of %s = %v, want %v", name, fi.ModTime(), fixedModTime)
+		}
+	}
+}
+
+func TestPreserveModTimeBadHeader(t *testing.T) {
+	for _, lastModified := range []string{"", "yesterday"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if lastModified != "" {
+				w.Header().Set("Last-Modified", lastModified)
+			}
+			w.Write([]byte("content"))
+		}))
+		dir := t.TempDir()
+		d := NewDownloader(DownloadOptions{DownloadDir: dir, PreserveModTime: true})
+		before := time.Now().Add(-time.Minute)
+		if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+			t.Fatalf("Last-Modified %q: %v", lastModified, err)
+		}
+		srv.Close()
+		if fi, err := os.Stat(filepath.Join(dir, "f.bin")); err != nil || fi.ModTime().Before(before) {
+			t.Errorf("Last-Modified %q: got %v, want the file with the local modification time", lastModified, err)
+		}
+	}
+}
+
+func TestSkipIfUnmodified(t *testing.T) {
+	data := fixture(1000)
+	var gets, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets++
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		http.ServeContent(rec, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+		if rec.status == http.StatusNotModified {
+			notModified++
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, PreserveModTime: true, SkipIfUnmodified: true})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "f.bin")
+
+	// The local copy is as new as the server's file.
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != StatusCached || results[0].Path != path || results[0].Size != int64(len(data)) {
+		t.Errorf("DownloadAll() = %+v, want the cached local copy", results[0])
+	}
+	if gets != 1 || notModified != 1 {
+		t.Errorf("got %d GET requests and %d 304 responses, want the second download answered with 304", gets, notModified)
+	}
+
+	// An older local copy is replaced.
+	old := fixedModTime.Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	results, err = d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != StatusDownloaded || gets != 2 {
+		t.Errorf("DownloadAll() of an outdated copy = %v with %d GET requests, want it downloaded again", results[0].Status, gets)
+	}
+}
+
+// statusRecorder records the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,247 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNameLen is the longest local file name in bytes. It stays below the
+// 255 bytes most filesystems allow, leaving room for the affixes of temp
+// files and resume manifests.
+const maxNameLen = 255 - 32
+
+// validateFileName rejects user supplied file names which could escape
+// the downloadDir, like absolute paths or names containing "..".
+func validateFileName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: file name is empty", ErrInvalidFileName)
+	}
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") {
+		return fmt.Errorf("%w: file name %q must not be an absolute path", ErrInvalidFileName, name)
+	}
+	for _, elem := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return fmt.Errorf("%w: file name %q must not contain \"..\"", ErrInvalidFileName, name)
+		}
+	}
+	return nil
+}
+
+// urlFileName returns the percent-decoded last path segment of rawURL, so
+// query strings and fragments don't end up in the local file name. Urls
+// which don't parse fall back to the text after the last "/".
+func urlFileName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL[strings.LastIndex(rawURL, "/")+1:]
+	}
+	return path.Base(u.Path)
+}
+
+// safeName reduces a raw, possibly server supplied, file name to its last
+// path element, shortened to maxNameLen. Empty names and names resolving to
+// "." or ".." are rejected.
+func safeName(raw string) (string, error) {
+	name := path.Base(strings.ReplaceAll(raw, "\\", "/"))
+	switch name {
+	case "", ".", "..", "/":
+		return "", fmt.Errorf("%w %q", ErrInvalidFileName, raw)
+	}
+	return shortenName(name), nil
+}
+
+// safeRelName is like safeName but keeps the directories of raw, for
+// PreservePath. Empty, "." and ".." segments are dropped, so the name never
+// leaves the download directory.
+func safeRelName(raw string) (string, error) {
+	var segments []string
+	for _, seg := range strings.FieldsFunc(raw, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if seg == "." || seg == ".." {
+			continue
+		}
+		segments = append(segments, shortenName(seg))
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("%w %q", ErrInvalidFileName, raw)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// cleanName sanitizes a file name with safeRelName when PreservePath keeps
+// directories and with safeName otherwise.
+func (d *Downloader) cleanName(raw string) (string, error) {
+	if d.downloadOptions.PreservePath {
+		return safeRelName(raw)
+	}
+	return safeName(raw)
+}
+
+// urlPath returns the percent-decoded path of rawURL without its leading
+// "/", the file name of PreservePath.
+func urlPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// hiddenName returns the name of a hidden file next to name, its base name
+// with a "." prefix and suffix appended.
+func hiddenName(name, suffix string) string {
+	return path.Join(path.Dir(name), "."+path.Base(name)+suffix)
+}
+
+// shortenName truncates names longer than maxNameLen. The extension is kept
+// and a hash of the full name is appended, so long names sharing a prefix
+// stay distinct.
+func shortenName(name string) string {
+	if len(name) <= maxNameLen {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+	ext := path.Ext(name)
+	if len(ext) > 16 {
+		// Too long to be a real extension.
+		ext = ""
+	}
+	cut := maxNameLen - len(suffix) - len(ext)
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut] + suffix + ext
+}
+
+// safePath joins the sanitized raw name to dir and makes sure the result
+// still lives inside dir. On Windows the name is made valid there and long
+// paths get the \\?\ prefix.
+func safePath(dir, raw string) (string, error) {
+	name, err := safeRelName(raw)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(dir, localName(name))
+	rel, err := filepath.Rel(filepath.Clean(dir), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: file name %q escapes download directory %s", ErrInvalidFileName, raw, dir)
+	}
+	return longPath(p), nil
+}
+
+// URLName is the default DownloadOptions.NameFunc, it names a file after the
+// percent-decoded last path segment of its url.
+func URLName(fileUrl string, resp *http.Response) (string, error) {
+	return urlFileName(fileUrl), nil
+}
+
+// fileName returns the sanitized local file name of req.
+func (d *Downloader) fileName(req fileRequest, resp *http.Response) (string, error) {
+	if req.named {
+		return req.fileName, nil
+	}
+	name := req.fileName
+	if d.downloadOptions.NameFunc != nil {
+		raw, err := d.downloadOptions.NameFunc(req.url, resp)
+		if err != nil {
+			return "", fmt.Errorf("error while naming the file of %s: %w", req.url, err)
+		}
+		if name, err = d.cleanName(raw); err != nil {
+			return "", err
+		}
+	}
+	if d.downloadOptions.FixExtension && resp != nil {
+		name = fixExtension(name, resp.Header.Get("Content-Type"))
+	}
+	return name, nil
+}
+
+// preferredExtensions picks the usual extension of media types which
+// mime.ExtensionsByType knows several extensions of.
+var preferredExtensions = map[string]string{
+	"audio/mpeg":         ".mp3",
+	"image/jpeg":         ".jpg",
+	"image/tiff":         ".tif",
+	"text/html":          ".html",
+	"video/mp4":          ".mp4",
+	"video/mpeg":         ".mpg",
+	"application/x-tar":  ".tar",
+	"application/x-gzip": ".gz",
+}
+
+// genericTypes are media types which say nothing about the content.
+// text/plain is the fallback of many servers for any text file.
+var genericTypes = map[string]bool{
+	"text/plain":                 true,
+	"application/octet-stream":   true,
+	"binary/octet-stream":        true,
+	"application/download":       true,
+	"application/force-download": true,
+	"application/x-download":     true,
+}
+
+// fixExtension gives name the extension of contentType, see
+// DownloadOptions.FixExtension. A name without an extension or with one of
+// no known type gets the extension of contentType appended, a known
+// extension is never replaced: servers often send a type close to the
+// content but not quite it.
+func fixExtension(name, contentType string) string {
+	if ext := path.Ext(name); ext != "" && mime.TypeByExtension(ext) != "" {
+		return name
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || genericTypes[mediaType] {
+		return name
+	}
+	want, ok := preferredExtensions[mediaType]
+	if !ok {
+		exts, _ := mime.ExtensionsByType(mediaType)
+		if len(exts) == 0 {
+			return name
+		}
+		want = exts[0]
+	}
+	return name + want
+}
+
+// windowsReserved are the device names Windows doesn't allow as file names,
+// with or without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeWindowsName makes name valid on Windows: characters it doesn't
+// allow are replaced with "_", trailing dots and spaces are dropped and
+// reserved device names like CON or COM1.txt get a "_" prefix.
+func sanitizeWindowsName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+	base := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		base = name[:i]
+	}
+	if windowsReserved[strings.ToUpper(strings.TrimRight(base, " "))] {
+		return "_" + name
+	}
+	return name
+}
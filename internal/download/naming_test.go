@@ -0,0 +1,384 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDownloadNamed(t *testing.T) {
+	srv := serve(map[string][]byte{"/get": fixture(100)})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir})
+	paths, err := d.DownloadNamed(map[string]string{srv.URL + "/get": "report.pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != filepath.Join(dir, "report.pdf") {
+		t.Errorf("DownloadNamed() = %v, want report.pdf in the download dir", paths)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "report.pdf")); err != nil || !bytes.Equal(b, fixture(100)) {
+		t.Errorf("report.pdf has %d bytes and %v, want the served file", len(b), err)
+	}
+
+	for _, name := range []string{"", "../escape.bin", "a/../../escape.bin", "/etc/passwd", "\\server\\share", "..\\escape.bin"} {
+		_, err := d.DownloadNamed(map[string]string{srv.URL + "/get": name})
+		if !errors.Is(err, ErrInvalidFileName) {
+			t.Errorf("DownloadNamed(%q) error = %v, want ErrInvalidFileName", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.bin")); !os.IsNotExist(err) {
+		t.Errorf("a rejected name escaped the download dir: %v", err)
+	}
+}
+
+func TestURLFileName(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"http://example.com/a/b.zip", "b.zip"},
+		{"https://example.com/data/Geographic-units-CSV.zip?token=abc&x=1", "Geographic-units-CSV.zip"},
+		{"http://example.com/a/b.txt#section", "b.txt"},
+		{"http://example.com/a/b.txt?q=1#section", "b.txt"},
+		{"http://example.com/a/my%20file%2Bv2.csv", "my file+v2.csv"},
+		{"http://example.com/%E2%82%AC.txt", "€.txt"},
+		// Decoded separators are still path separators.
+		{"http://example.com/a/%2F..%2Fescape", "escape"},
+		{"ftp://example.com/pub/f.bin", "f.bin"},
+		{"http://example.com/dir/", "dir"},
+		// No name at all, rejected by safeName.
+		{"http://example.com", "."},
+	}
+	for _, tt := range tests {
+		if got := urlFileName(tt.url); got != tt.want {
+			t.Errorf("urlFileName(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"file.bin", "file.bin"},
+		{"../../etc/passwd", "passwd"},
+		{"/etc/passwd", "passwd"},
+		{"..\\..\\windows\\win.ini", "win.ini"},
+		{"dir/", "dir"},
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		{"/", ""},
+		{"a/..", ""},
+	}
+	for _, tt := range tests {
+		got, err := safeName(tt.raw)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidFileName) {
+				t.Errorf("safeName(%q) = %q, %v, want ErrInvalidFileName", tt.raw, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("safeName(%q) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestSafePath(t *testing.T) {
+	dir := t.TempDir()
+	for _, raw := range []string{"../../etc/passwd", "/etc/passwd", "a/../../../b", "..\\..\\b"} {
+		p, err := safePath(dir, raw)
+		if err != nil {
+			t.Errorf("safePath(%q) error = %v", raw, err)
+			continue
+		}
+		if rel, err := filepath.Rel(dir, p); err != nil || strings.HasPrefix(rel, "..") {
+			t.Errorf("safePath(%q) = %s, outside of %s", raw, p, dir)
+		}
+	}
+	if _, err := safePath(dir, "../.."); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("safePath(\"../..\") error = %v, want ErrInvalidFileName", err)
+	}
+}
+
+func TestMaliciousServerName(t *testing.T) {
+	srv := serve(map[string][]byte{"/f": fixture(100)})
+	defer srv.Close()
+
+	dir := filepath.Join(t.TempDir(), "downloads")
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NameFunc: func(string, *http.Response) (string, error) {
+		return "../../etc/passwd", nil
+	}})
+	paths, err := d.Download(srv.URL + "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths[0] != filepath.Join(dir, "passwd") {
+		t.Errorf("Download() = %v, want passwd in %s", paths, dir)
+	}
+}
+
+func TestNameFunc(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../report.csv"`)
+		w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	// template names files {host}_{name}.
+	template := func(fileUrl string, resp *http.Response) (string, error) {
+		u, err := url.Parse(fileUrl)
+		if err != nil {
+			return "", err
+		}
+		name, err := URLName(fileUrl, resp)
+		return u.Hostname() + "_" + name, err
+	}
+	disposition := func(fileUrl string, resp *http.Response) (string, error) {
+		_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		return params["filename"], err
+	}
+	hashed := func(fileUrl string, resp *http.Response) (string, error) {
+		return sha256Hex([]byte(fileUrl))[:16] + ".bin", nil
+	}
+	tests := []struct {
+		name     string
+		nameFunc func(string, *http.Response) (string, error)
+		want     string
+	}{
+		{"default", nil, "a.bin"},
+		{"template", template, "127.0.0.1_a.bin"},
+		// The suggested directory is stripped.
+		{"content disposition", disposition, "report.csv"},
+		{"hash", hashed, sha256Hex([]byte(srv.URL + "/a.bin?x=1"))[:16] + ".bin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			d := NewDownloader(DownloadOptions{DownloadDir: dir, NameFunc: tt.nameFunc})
+			paths, err := d.Download(srv.URL + "/a.bin?x=1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if paths[0] != filepath.Join(dir, tt.want) {
+				t.Errorf("Download() = %q, want %s", paths[0], tt.want)
+			}
+		})
+	}
+
+	failing := errors.New("no name")
+	d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), NameFunc: func(string, *http.Response) (string, error) {
+		return "", failing
+	}})
+	if _, err := d.Download(srv.URL + "/a.bin"); !errors.Is(err, failing) {
+		t.Errorf("Download() error = %v, want the error of NameFunc", err)
+	}
+	// Names given to DownloadNamed are kept.
+	if paths, err := d.DownloadNamed(map[string]string{srv.URL + "/a.bin": "named.bin"}); err != nil || filepath.Base(paths[0]) != "named.bin" {
+		t.Errorf("DownloadNamed() = %q, %v, want named.bin", paths, err)
+	}
+}
+
+func TestFixExtension(t *testing.T) {
+	tests := []struct {
+		name, contentType, want string
+	}{
+		{"download", "application/zip", "download.zip"},
+		{"report", "application/pdf", "report.pdf"},
+		{"photo", "image/jpeg", "photo.jpg"},
+		{"page", "text/html; charset=utf-8", "page.html"},
+		{"archive.v2", "application/zip", "archive.v2.zip"},
+		// Known extensions are never replaced.
+		{"report.pdf", "application/pdf", "report.pdf"},
+		{"photo.JPG", "image/jpeg", "photo.JPG"},
+		{"report.pdf", "application/zip", "report.pdf"},
+		{"image.png", "text/html", "image.png"},
+		// Generic types say nothing about the content.
+		{"data.csv", "text/plain", "data.csv"},
+		{"notes", "text/plain; charset=utf-8", "notes"},
+		{"blob", "application/octet-stream", "blob"},
+		{"blob", "", "blob"},
+		{"blob", "not a type", "blob"},
+		{"blob", "application/x-unknown-type", "blob"},
+	}
+	for _, tt := range tests {
+		if got := fixExtension(tt.name, tt.contentType); got != tt.want {
+			t.Errorf("fixExtension(%q, %q) = %q, want %q", tt.name, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestFixExtensionDownload(t *testing.T) {
+	types := map[string]string{"/get": "application/zip", "/file.pdf": "application/zip", "/named": "application/zip"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", types[r.URL.Path])
+		w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, FixExtension: true})
+	if _, err := d.Download(srv.URL+"/get", srv.URL+"/file.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DownloadNamed(map[string]string{srv.URL + "/named": "named.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"get.zip", "file.pdf", "named.bin"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestSanitizeWindowsName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"a?b:c*.zip", "a_b_c_.zip"},
+		{`x<y>z"|\.txt`, "x_y_z___.txt"},
+		{"tab\there", "tab_here"},
+		{"trail. . ", "trail"},
+		{"...", "_"},
+		{"", "_"},
+		// Reserved device names, with or without an extension.
+		{"CON", "_CON"},
+		{"con.txt", "_con.txt"},
+		{"COM1.tar.gz", "_COM1.tar.gz"},
+		{"nul ", "_nul"},
+		{"LPT9", "_LPT9"},
+		// Names merely starting with one are fine.
+		{"CONSOLE.txt", "CONSOLE.txt"},
+		{"COM10", "COM10"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeWindowsName(tt.name); got != tt.want {
+			t.Errorf("sanitizeWindowsName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestShortenName(t *testing.T) {
+	long := strings.Repeat("x", 400)
+	tests := []struct {
+		name, ext string
+	}{
+		{long + ".bin", ".bin"},
+		{strings.Repeat("é", 200) + ".tar.gz", ".gz"},
+		// An "extension" that long is part of the name.
+		{"a." + long, ""},
+		{long, ""},
+	}
+	seen := map[string]bool{}
+	for _, tt := range tests {
+		got := shortenName(tt.name)
+		if len(got) > maxNameLen || !utf8.ValidString(got) {
+			t.Errorf("shortenName() of %d bytes = %d bytes, valid UTF-8: %v", len(tt.name), len(got), utf8.ValidString(got))
+		}
+		if tt.ext != "" && filepath.Ext(got) != tt.ext {
+			t.Errorf("shortenName() = %q, want the extension %q kept", got, tt.ext)
+		}
+		if seen[got] {
+			t.Errorf("shortenName() gave %q twice", got)
+		}
+		seen[got] = true
+	}
+	// Names sharing the first maxNameLen bytes stay distinct.
+	if a, b := shortenName(long+"a.bin"), shortenName(long+"b.bin"); a == b {
+		t.Errorf("shortenName() of two long names = %q for both", a)
+	}
+	if got := shortenName("short.bin"); got != "short.bin" {
+		t.Errorf("shortenName(short.bin) = %q", got)
+	}
+}
+
+func TestLongURLName(t *testing.T) {
+	name := strings.Repeat("x", 400) + ".bin"
+	srv := serve(map[string][]byte{"/" + name: fixture(100)})
+	defer srv.Close()
+
+	// With Resume the partial file and manifest get the longest affixes.
+	d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), Resume: true})
+	paths, err := d.Download(srv.URL + "/" + name + "?" + strings.Repeat("q=1&", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base := filepath.Base(paths[0]); len(base) > maxNameLen || filepath.Ext(base) != ".bin" {
+		t.Errorf("Download() of a %d byte name = %s, want at most %d bytes ending in .bin", len(name), base, maxNameLen)
+	}
+}
+
+func TestSafeRelName(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"a/b/c.csv", "a/b/c.csv"},
+		{"/a/b/c.csv", "a/b/c.csv"},
+		{"a//b/./c.csv", "a/b/c.csv"},
+		{"a/../../x/y.bin", "a/x/y.bin"},
+		{"../../etc/passwd", "etc/passwd"},
+		{`a\..\b.txt`, "a/b.txt"},
+	}
+	for _, tt := range tests {
+		if got, err := safeRelName(tt.raw); err != nil || got != tt.want {
+			t.Errorf("safeRelName(%q) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+	for _, raw := range []string{"", "/", "../..", "./."} {
+		if _, err := safeRelName(raw); !errors.Is(err, ErrInvalidFileName) {
+			t.Errorf("safeRelName(%q) error = %v, want ErrInvalidFileName", raw, err)
+		}
+	}
+}
+
+func TestPreservePath(t *testing.T) {
+	srv := serve(map[string][]byte{"/a/b/c.csv": fixture(100), "/a/../../x/y.bin": fixture(200), "/top.txt": fixture(300)})
+	defer srv.Close()
+
+	for _, resume := range []bool{false, true} {
+		dir := t.TempDir()
+		d := NewDownloader(DownloadOptions{DownloadDir: dir, PreservePath: true, Resume: resume})
+		// The encoded dots reach the server as they are and are dropped
+		// from the local path.
+		results, err := d.DownloadAll(context.Background(), srv.URL+"/a/b/c.csv", srv.URL+"/a/%2e%2e/%2e%2e/x/y.bin", srv.URL+"/top.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range []string{"a/b/c.csv", "a/x/y.bin", "top.txt"} {
+			if p := results[i].Path; p != filepath.Join(dir, filepath.FromSlash(want)) {
+				t.Errorf("path of %s with Resume %v = %s, want %s under %s", results[i].URL, resume, p, want, dir)
+			}
+		}
+		var files []string
+		filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if len(files) != 3 {
+			t.Errorf("download dir with Resume %v has the files %q, want the 3 downloaded", resume, files)
+		}
+	}
+
+	// Without PreservePath the directories are flattened.
+	dir := t.TempDir()
+	results, err := NewDownloader(DownloadOptions{DownloadDir: dir}).DownloadAll(context.Background(), srv.URL+"/a/b/c.csv")
+	if err != nil || results[0].Path != filepath.Join(dir, "c.csv") {
+		t.Errorf("DownloadAll() = %q, %v, want c.csv in %s", results[0].Path, err, dir)
+	}
+}
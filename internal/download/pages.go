@@ -0,0 +1,146 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxPages is the most pages FollowNextLinks downloads into one file.
+const maxPages = 10000
+
+// downloadPages downloads fileUrl and, one after the other, every page it
+// links to with rel="next" into a single file, see
+// DownloadOptions.FollowNextLinks.
+func (d *Downloader) downloadPages(ctx context.Context, req fileRequest, p probe) (DownloadResult, error) {
+	fileHash, err := newHash(d.downloadOptions.ComputeHash)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	expected, wantSum, err := d.expectedHash(req.url)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	outFile, err := d.createOutputFile(ctx, req, p.name, p.replace)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	outputFilePath := outFile.path
+	defer outFile.abort()
+
+	var out io.Writer = outFile
+	for _, h := range []hash.Hash{fileHash, expected} {
+		if h != nil {
+			out = io.MultiWriter(out, h)
+		}
+	}
+	// The HEAD size, the one of the first page, was counted against
+	// MaxTotalBytes with the sizes of the batch already.
+	var prepaid int64
+	if p.size > 0 {
+		prepaid = p.size
+	}
+	out = prepaidBudgetWriter(ctx, out, prepaid)
+	if wantsEvents(ctx) {
+		var downloaded int64
+		out = &progressWriter{ctx: ctx, w: out, url: req.url, downloaded: &downloaded, speed: &speedMeter{}}
+	}
+
+	seen := make(map[string]bool)
+	var w int64
+	next, pages := req.url, 0
+	for ; next != ""; pages++ {
+		if seen[next] {
+			return DownloadResult{}, fmt.Errorf("error while following the pages of %s: %s is linked twice", req.url, next)
+		}
+		if pages == maxPages {
+			return DownloadResult{}, fmt.Errorf("error while following the pages of %s: more than %d pages", req.url, maxPages)
+		}
+		seen[next] = true
+		d.printf("downloading page %d of %s from %s\n", pages+1, req.url, next)
+		n, link, err := d.fetchPage(ctx, req, next, out)
+		w += n
+		if err != nil {
+			return DownloadResult{}, fmt.Errorf("error while downloading page %s of %s: %w", next, req.url, err)
+		}
+		next = link
+	}
+	d.printf("Wrote %d pages to File : %v, Written bytes : %v\n", pages, outputFilePath, w)
+	if err := d.checkExpectedSize(req.url, w); err != nil {
+		return DownloadResult{}, err
+	}
+	if err := checkExpectedHash(outputFilePath, expected, wantSum); err != nil {
+		return DownloadResult{}, err
+	}
+
+	if err := outFile.commit(); err != nil {
+		return DownloadResult{}, err
+	}
+
+	return DownloadResult{Path: outputFilePath, Size: w, Concurrency: 1, Hash: hexSum(fileHash)}, nil
+}
+
+// fetchPage copies the body of pageUrl, a page of req, to out and returns the absolute url
+// of its rel="next" link, "" for the last page.
+func (d *Downloader) fetchPage(ctx context.Context, req fileRequest, pageUrl string, out io.Writer) (int64, string, error) {
+	request, err := req.newRequest(ctx, "GET", pageUrl)
+	if err != nil {
+		return 0, "", err
+	}
+	response, err := d.do(request)
+	if err != nil {
+		return 0, "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, "", newStatusError(response)
+	}
+	n, err := d.copyWithBuffer(out, d.pause.reader(ctx, response.Body, nil))
+	if err != nil {
+		return n, "", err
+	}
+	return n, nextLink(response.Request.URL, response.Header), nil
+}
+
+// nextLink returns the target of the rel="next" link of the Link header,
+// resolved against base, or "" when there is none.
+func nextLink(base *url.URL, header http.Header) string {
+	for _, v := range header.Values("Link") {
+		for {
+			start := strings.IndexByte(v, '<')
+			end := strings.IndexByte(v, '>')
+			if start < 0 || end < start {
+				break
+			}
+			target := v[start+1 : end]
+			v = v[end+1:]
+			// The parameters of the link run until the next one starts.
+			params := v
+			if i := strings.IndexByte(v, '<'); i >= 0 {
+				params, v = v[:i], v[i:]
+			}
+			for _, param := range strings.Split(params, ";") {
+				i := strings.IndexByte(param, '=')
+				if i < 0 || !strings.EqualFold(strings.TrimSpace(param[:i]), "rel") {
+					continue
+				}
+				rels := strings.Trim(strings.TrimSpace(strings.TrimRight(strings.TrimSpace(param[i+1:]), ",")), `"`)
+				for _, rel := range strings.Fields(rels) {
+					if !strings.EqualFold(rel, "next") {
+						continue
+					}
+					u, err := base.Parse(target)
+					if err != nil {
+						return ""
+					}
+					return u.String()
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNextLink(t *testing.T) {
+	base, _ := url.Parse("https://api.example.com/export?page=1")
+	tests := []struct {
+		links []string
+		want  string
+	}{
+		{[]string{`<https://api.example.com/export?page=2>; rel="next"`}, "https://api.example.com/export?page=2"},
+		{[]string{`<?page=2>; rel=next`}, "https://api.example.com/export?page=2"},
+		{[]string{`</other>; rel="prev next"`}, "https://api.example.com/other"},
+		{[]string{`<https://x/last>; rel="last", <?page=2>; title="n"; REL="Next"`}, "https://api.example.com/export?page=2"},
+		{[]string{`<https://x/first>; rel="first"`, `<?page=3>; rel="next"`}, "https://api.example.com/export?page=3"},
+		{[]string{`<https://x/prev>; rel="prev"`}, ""},
+		{[]string{`<https://x/nextpage>; rel="nextpage"`}, ""},
+		{[]string{`no link`}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		header := http.Header{"Link": tt.links}
+		if got := nextLink(base, header); got != tt.want {
+			t.Errorf("nextLink(%q) = %q, want %q", tt.links, got, tt.want)
+		}
+	}
+}
+
+func TestFollowNextLinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", `<?page=2>; rel="next"`)
+			io.WriteString(w, "one,")
+		case "2":
+			w.Header().Set("Link", `</export?page=3>; rel="next"`)
+			io.WriteString(w, "two,")
+		case "3":
+			io.WriteString(w, "three")
+		case "loop":
+			w.Header().Set("Link", `<?page=loop>; rel="next"`)
+			io.WriteString(w, "again")
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, FollowNextLinks: true})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, "export"))
+	if string(b) != "one,two,three" || results[0].Size != 13 {
+		t.Errorf("downloaded %q in %d bytes, want the pages in order", b, results[0].Size)
+	}
+
+	// Without FollowNextLinks only the first page is downloaded.
+	dir = t.TempDir()
+	if _, err := NewDownloader(DownloadOptions{DownloadDir: dir}).DownloadAll(context.Background(), srv.URL+"/export"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "export")); string(b) != "one," {
+		t.Errorf("downloaded %q without FollowNextLinks, want the first page", b)
+	}
+
+	// A page linking to itself would never end.
+	dir = t.TempDir()
+	d = NewDownloader(DownloadOptions{DownloadDir: dir, FollowNextLinks: true})
+	_, err = d.DownloadAll(context.Background(), srv.URL+"/loop?page=loop")
+	if err == nil || !strings.Contains(err.Error(), "linked twice") {
+		t.Errorf("DownloadAll() of a page loop error = %v, want it detected", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the failed page download left %d files behind", len(entries))
+	}
+}
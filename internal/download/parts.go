@@ -0,0 +1,127 @@
+package download
+
+const (
+	// splitThreshold is the size above which files are split into parts.
+	splitThreshold = 1024 * 1024 * 10
+	// defaultMinPartSize is used when DownloadOptions.MinPartSize is unset.
+	defaultMinPartSize = 1024 * 1024
+)
+
+// byteRange is an inclusive range of bytes of a file, a negative end means
+// until the end of the file.
+type byteRange struct {
+	start, end int64
+}
+
+// knownSize returns contentLength, or zero when it is unknown.
+func knownSize(contentLength int64) int64 {
+	if contentLength < 0 {
+		return 0
+	}
+	return contentLength
+}
+
+// minPartSize returns MinPartSize or its default.
+func (o DownloadOptions) minPartSize() int64 {
+	if o.MinPartSize <= 0 {
+		return defaultMinPartSize
+	}
+	return o.MinPartSize
+}
+
+// partCount returns the number of parts a file of contentLength bytes is
+// downloaded in: NumConcParts clamped to [1, MaxParts] and lowered so that no
+// part is smaller than MinPartSize. Files <= 10MB are never split.
+func (o DownloadOptions) partCount(contentLength int64) int {
+	if contentLength <= splitThreshold {
+		return 1
+	}
+
+	n := o.NumConcParts
+	if o.MaxParts > 0 && n > o.MaxParts {
+		n = o.MaxParts
+	}
+	if limit := contentLength / o.minPartSize(); int64(n) > limit {
+		n = int(limit)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// weightedParts shares a budget of parts among files of sizes in proportion
+// to their size. Files which are never split, including those of unknown
+// (negative) size, get one part each out of the budget, every other file
+// at least one. The shares of the split files sum up to the rest of the
+// budget unless there are more files than parts.
+func weightedParts(sizes []int64, budget int) []int {
+	parts := make([]int, len(sizes))
+	var total int64
+	split := 0
+	for i, size := range sizes {
+		parts[i] = 1
+		if size > splitThreshold {
+			total += size
+			split++
+		} else {
+			budget--
+		}
+	}
+	if split == 0 || budget <= split {
+		return parts
+	}
+	// Hand out the whole parts first, then one more to each of the files
+	// with the largest remainders.
+	left := budget
+	rems := make([]int64, len(sizes))
+	for i, size := range sizes {
+		if size <= splitThreshold {
+			continue
+		}
+		share := int64(budget) * size
+		parts[i] = int(share / total)
+		rems[i] = share % total
+		if parts[i] < 1 {
+			parts[i], rems[i] = 1, 0
+		}
+		left -= parts[i]
+	}
+	for ; left > 0; left-- {
+		best := -1
+		for i, size := range sizes {
+			if size > splitThreshold && (best < 0 || rems[i] > rems[best]) {
+				best = i
+			}
+		}
+		parts[best]++
+		rems[best] = -1
+	}
+	return parts
+}
+
+// splitRanges splits contentLength bytes into n ranges of equal size, the
+// last range also gets the remaining bytes. n is clamped to [1,
+// contentLength] so no range is empty. An unknown (negative) contentLength
+// results in a single open ended range, an empty file in no ranges at all.
+func splitRanges(contentLength int64, n int) []byteRange {
+	if contentLength < 0 {
+		return []byteRange{{start: 0, end: -1}}
+	}
+	if contentLength == 0 {
+		return nil
+	}
+	if int64(n) > contentLength {
+		n = int(contentLength)
+	}
+	if n < 1 {
+		n = 1
+	}
+	per := contentLength / int64(n)
+	ranges := make([]byteRange, n)
+	for i := range ranges {
+		ranges[i] = byteRange{start: per * int64(i), end: per*int64(i+1) - 1}
+	}
+	ranges[n-1].end = contentLength - 1
+	return ranges
+}
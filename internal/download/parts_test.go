@@ -0,0 +1,134 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPartCount(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name string
+		opts DownloadOptions
+		size int64
+		want int
+	}{
+		{"small file", DownloadOptions{NumConcParts: 8}, 10 * mb, 1},
+		{"unknown size", DownloadOptions{NumConcParts: 8}, -1, 1},
+		{"configured", DownloadOptions{NumConcParts: 4}, 100 * mb, 4},
+		{"unset", DownloadOptions{}, 100 * mb, 1},
+		{"max parts", DownloadOptions{NumConcParts: 16, MaxParts: 6}, 100 * mb, 6},
+		{"max parts above", DownloadOptions{NumConcParts: 4, MaxParts: 6}, 100 * mb, 4},
+		{"default min part size", DownloadOptions{NumConcParts: 64}, 12 * mb, 12},
+		{"min part size", DownloadOptions{NumConcParts: 64, MinPartSize: 5 * mb}, 12 * mb, 2},
+		{"min part size rounds down", DownloadOptions{NumConcParts: 8, MinPartSize: 3 * mb}, 23 * mb, 7},
+		{"min part size above file", DownloadOptions{NumConcParts: 8, MinPartSize: 100 * mb}, 12 * mb, 1},
+		{"both", DownloadOptions{NumConcParts: 100, MaxParts: 50, MinPartSize: 10 * mb}, 1 << 30, 50},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.partCount(tt.size); got != tt.want {
+			t.Errorf("%s: partCount(%d) = %d, want %d", tt.name, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestSplitRanges(t *testing.T) {
+	tests := []struct {
+		size int64
+		n    int
+		want []byteRange
+	}{
+		{10, 1, []byteRange{{0, 9}}},
+		{10, 3, []byteRange{{0, 2}, {3, 5}, {6, 9}}},
+		{3, 5, []byteRange{{0, 0}, {1, 1}, {2, 2}}},
+		{10, 0, []byteRange{{0, 9}}},
+		{0, 3, nil},
+		{-1, 3, []byteRange{{0, -1}}},
+	}
+	for _, tt := range tests {
+		if got := splitRanges(tt.size, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitRanges(%d, %d) = %v, want %v", tt.size, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTinyFileManyParts(t *testing.T) {
+	data := []byte("hello")
+	srv := newRangeServer(data)
+	defer srv.Close()
+
+	for _, storage := range []Storage{LocalStorage{Dir: t.TempDir()}, newMemStorage()} {
+		d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), MinPartSize: 1})
+		results, err := d.DownloadEach(context.Background(), []Request{{URL: srv.URL + "/t.bin", NumConcParts: 10}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0].Size != 5 || results[0].Concurrency != 1 {
+			t.Errorf("result = %+v, want 5 bytes in a single part", results[0])
+		}
+		var got []byte
+		if m, ok := storage.(*memStorage); ok {
+			got = m.file("t.bin")
+		} else {
+			got, _ = os.ReadFile(results[0].Path)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("downloaded %q, want %q", got, data)
+		}
+		// Files that small are never split, no empty or inverted range is
+		// requested.
+		want := []string{"bytes=0-4"}
+		if got := srv.requested(); !reflect.DeepEqual(got, want) {
+			t.Errorf("requested %q, want %q", got, want)
+		}
+	}
+}
+
+func TestWeightedParts(t *testing.T) {
+	tests := []struct {
+		sizes  []int64
+		budget int
+		want   []int
+	}{
+		{[]int64{12 << 20, 60 << 20, 100}, 9, []int{1, 7, 1}},
+		{[]int64{11 << 20, 33 << 20}, 9, []int{2, 7}},
+		// Files of unknown size take one part of the budget.
+		{[]int64{20 << 20, 40 << 20, -1}, 10, []int{3, 6, 1}},
+		// More files to split than parts.
+		{[]int64{20 << 20, 20 << 20, 20 << 20}, 2, []int{1, 1, 1}},
+		{[]int64{100, 200}, 8, []int{1, 1}},
+		{nil, 4, []int{}},
+	}
+	for _, tt := range tests {
+		if got := weightedParts(tt.sizes, tt.budget); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("weightedParts(%v, %d) = %v, want %v", tt.sizes, tt.budget, got, tt.want)
+		}
+	}
+}
+
+func TestWeightedPartsDownload(t *testing.T) {
+	files := map[string][]byte{"/s.bin": fixture(12 << 20), "/l.bin": fixture(60 << 20), "/t.bin": fixture(100)}
+	srv := serve(files)
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), MaxLimitConcurrency: 9, NumConcParts: 4, WeightedParts: true})
+	results, err := d.DownloadEach(context.Background(), []Request{{URL: srv.URL + "/s.bin"}, {URL: srv.URL + "/l.bin"}, {URL: srv.URL + "/t.bin"}, {URL: srv.URL + "/s.bin", FileName: "own.bin", NumConcParts: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The parts of a request with its own NumConcParts are kept.
+	for i, want := range []int{1, 7, 1, 3} {
+		if got := results[i].Concurrency; got != want {
+			t.Errorf("Concurrency of %s = %d, want %d", results[i].URL, got, want)
+		}
+	}
+	for name, data := range map[string][]byte{"s.bin": files["/s.bin"], "l.bin": files["/l.bin"], "t.bin": files["/t.bin"], "own.bin": files["/s.bin"]} {
+		if !bytes.Equal(storage.file(name), data) {
+			t.Errorf("%s differs", name)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package download
+
+// localName returns name made valid for the local filesystem.
+func localName(name string) string {
+	return name
+}
+
+// longPath returns p, only Windows limits the length of paths.
+func longPath(p string) string {
+	return p
+}
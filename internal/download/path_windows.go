@@ -0,0 +1,36 @@
+package download
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is the length from which Windows needs the \\?\ prefix. It is
+// MAX_PATH minus the 8.3 file name directories must leave room for.
+const maxPath = 260 - 12
+
+// localName returns name made valid for the local filesystem, segment by
+// segment for the directories kept by PreservePath.
+func localName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, seg := range segments {
+		segments[i] = sanitizeWindowsName(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// longPath prefixes long paths with \\?\ so they aren't limited to
+// MAX_PATH.
+func longPath(p string) string {
+	if len(p) < maxPath || strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
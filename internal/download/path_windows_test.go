@@ -0,0 +1,35 @@
+package download
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	short := `C:\Users\me\Downloads\f.bin`
+	if got := longPath(short); got != short {
+		t.Errorf("longPath(%q) = %q, want it unchanged", short, got)
+	}
+	long := `C:\Users\me\Downloads\` + strings.Repeat("a", 300) + ".bin"
+	if got := longPath(long); got != `\\?\`+long {
+		t.Errorf("longPath() of %d bytes = %q, want the \\\\?\\ prefix", len(long), got)
+	}
+	if got := longPath(`\\?\` + long); got != `\\?\`+long {
+		t.Errorf("longPath() prefixed a prefixed path again: %q", got)
+	}
+	unc := `\\server\share\` + strings.Repeat("a", 300)
+	if got := longPath(unc); got != `\\?\UNC\server\share\`+strings.Repeat("a", 300) {
+		t.Errorf("longPath() of a UNC path = %q", got)
+	}
+	rel := strings.Repeat("a", 300)
+	if got := longPath(rel); !strings.HasPrefix(got, `\\?\`) || !filepath.IsAbs(got[4:]) {
+		t.Errorf("longPath() of a relative path = %q, want it made absolute", got)
+	}
+}
+
+func TestLocalName(t *testing.T) {
+	if got := localName("dir:1/CON/a?.txt"); got != "dir_1/_CON/a_.txt" {
+		t.Errorf("localName() = %q, want every segment sanitized", got)
+	}
+}
@@ -0,0 +1,93 @@
+package download
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Pause holds all downloads of d before their next read from the network
+// until Resume is called. Open connections are kept, but servers may close
+// them during a long pause, failing those parts unless MaxRetries allows
+// to request them again. Parts which haven't started wait before sending
+// their request.
+func (d *Downloader) Pause() {
+	d.pause.pause()
+}
+
+// Resume continues the downloads held by Pause.
+func (d *Downloader) Resume() {
+	d.pause.resume()
+}
+
+// pauseGate blocks readers while paused. The zero value is not paused.
+type pauseGate struct {
+	mu sync.Mutex
+	// resumed is non-nil while paused and closed on resume.
+	resumed chan struct{}
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+func (g *pauseGate) paused() chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed
+}
+
+// wait blocks while the gate is paused or until ctx is done.
+func (g *pauseGate) wait(ctx context.Context) error {
+	resumed := g.paused()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader wraps r so that reads wait while the gate is paused. The stall
+// timer, if any, doesn't run during the pause.
+func (g *pauseGate) reader(ctx context.Context, r io.Reader, stall *stallWatcher) io.Reader {
+	return &pauseReader{ctx: ctx, r: r, gate: g, stall: stall}
+}
+
+type pauseReader struct {
+	ctx   context.Context
+	r     io.Reader
+	gate  *pauseGate
+	stall *stallWatcher
+}
+
+func (r *pauseReader) Read(p []byte) (int, error) {
+	if r.gate.paused() != nil {
+		if r.stall != nil {
+			r.stall.stop()
+		}
+		if err := r.gate.wait(r.ctx); err != nil {
+			return 0, err
+		}
+		if r.stall != nil && !r.stall.fired() {
+			r.stall.timer.Reset(r.stall.timeout)
+		}
+	}
+	return r.r.Read(p)
+}
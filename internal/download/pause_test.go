@@ -0,0 +1,63 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	data := fixture(100000)
+	// The body trickles in, so the download is still running when paused.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100000")
+		if r.Method == http.MethodHead {
+			return
+		}
+		for off := 0; off < len(data); off += 1000 {
+			w.Write(data[off : off+1000])
+			w.(http.Flusher).Flush()
+			time.Sleep(3 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage})
+	var downloaded int64
+	paused := false
+	var result DownloadResult
+	for ev := range d.DownloadStream(context.Background(), srv.URL+"/f.bin") {
+		switch ev.Type {
+		case Progress:
+			atomic.StoreInt64(&downloaded, ev.Downloaded)
+			if ev.Downloaded > 10000 && !paused {
+				d.Pause()
+				paused = true
+				go func() {
+					// Let the read in flight finish, then no bytes may
+					// arrive until Resume.
+					time.Sleep(50 * time.Millisecond)
+					before := atomic.LoadInt64(&downloaded)
+					time.Sleep(300 * time.Millisecond)
+					if after := atomic.LoadInt64(&downloaded); after != before {
+						t.Errorf("progress went from %d to %d bytes while paused", before, after)
+					}
+					if before == int64(len(data)) {
+						t.Error("the download completed before it was paused")
+					}
+					d.Resume()
+				}()
+			}
+		case FileDone, Failed:
+			result = ev.Result
+		}
+	}
+	if result.Err != nil || !bytes.Equal(storage.file("f.bin"), data) {
+		t.Fatalf("result = %+v, want the download completed after Resume", result)
+	}
+}
@@ -0,0 +1,17 @@
+package download
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate reserves size bytes of disk space for f, so a full disk fails
+// the download before it starts and the parts land in contiguous blocks.
+// Filesystems without fallocate are left to the Truncate which follows.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == syscall.ENOSPC {
+		return err
+	}
+	return nil
+}
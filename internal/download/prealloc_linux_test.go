@@ -0,0 +1,31 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	const size = 4 << 20
+	if err := preallocate(f, size); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := fi.Sys().(*syscall.Stat_t).Blocks
+	if blocks == 0 {
+		t.Skip("the filesystem of the temp dir has no fallocate")
+	}
+	if fi.Size() != size || blocks*512 < size {
+		t.Errorf("preallocated file has %d bytes in %d blocks, want %d bytes reserved", fi.Size(), blocks, size)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package download
+
+import "os"
+
+// preallocate is a no-op, only Linux has fallocate. The Truncate which
+// follows sets the size of f.
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
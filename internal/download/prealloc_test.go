@@ -0,0 +1,53 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPreallocatedBeforeWriting(t *testing.T) {
+	data := fixture(12 << 20)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			<-release
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 4})
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.Download(srv.URL + "/f.bin")
+		done <- err
+	}()
+
+	// While no part got a byte the partial file already has the full size.
+	var size int64
+	for deadline := time.Now().Add(5 * time.Second); size == 0 && time.Now().Before(deadline); {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err == nil {
+				size = fi.Size()
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("partial file had %d bytes before the parts were written, want %d", size, len(data))
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "f.bin")); !bytes.Equal(b, data) {
+		t.Error("downloaded file differs")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package download
+
+// raceEnabled is set when the tests run with the race detector, which makes
+// sync.Pool drop some of the values put back.
+const raceEnabled = true
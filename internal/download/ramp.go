@@ -0,0 +1,42 @@
+package download
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rampGate spaces the starts of the probes and parts of one Download call
+// at least interval apart, see DownloadOptions.RampUp.
+type rampGate struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// next is the earliest time of the next start.
+	next time.Time
+}
+
+type rampGateKey struct{}
+
+// withRampUp returns a context whose starts are spaced interval apart.
+func withRampUp(ctx context.Context, interval time.Duration) context.Context {
+	return context.WithValue(ctx, rampGateKey{}, &rampGate{interval: interval})
+}
+
+// rampUp waits for the turn of the next start of ctx, it returns at once
+// when ctx has no rampGate.
+func rampUp(ctx context.Context) error {
+	r, _ := ctx.Value(rampGateKey{}).(*rampGate)
+	if r == nil {
+		return nil
+	}
+	now := time.Now()
+	r.mu.Lock()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	r.mu.Unlock()
+	return sleepContext(ctx, at.Sub(now))
+}
@@ -0,0 +1,75 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRampGate(t *testing.T) {
+	ctx := withRampUp(context.Background(), 30*time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := rampUp(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first start is immediate, the other 3 wait their turn.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("4 starts took %v, want about 90ms", elapsed)
+	}
+
+	if err := rampUp(context.Background()); err != nil {
+		t.Errorf("rampUp() without a gate = %v", err)
+	}
+	ctx, cancel := context.WithCancel(withRampUp(context.Background(), time.Hour))
+	rampUp(ctx)
+	cancel()
+	if err := rampUp(ctx); err == nil {
+		t.Error("rampUp() after ctx was done succeeded")
+	}
+}
+
+func TestRampUp(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := serve(map[string][]byte{"/a.bin": data, "/b.bin": data})
+	defer srv.Close()
+
+	// The starts are recorded as the requests are sent, the handlers would
+	// see them later by a varying delay.
+	var mu sync.Mutex
+	var heads, gets []time.Time
+	record := func(r *http.Request) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodHead {
+			heads = append(heads, time.Now())
+		} else {
+			gets = append(gets, time.Now())
+		}
+		return nil
+	}
+	const interval = 100 * time.Millisecond
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage(), TempDir: t.TempDir(), NumConcParts: 4, RampUp: interval, RequestMiddleware: []func(*http.Request) error{record}})
+	if _, err := d.Download(srv.URL+"/a.bin", srv.URL+"/b.bin"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(heads) != 2 || len(gets) != 8 {
+		t.Fatalf("got %d HEAD and %d GET requests, want 2 and 8", len(heads), len(gets))
+	}
+	// Every start waits for its own turn, allowing half an interval for
+	// the scheduling of the goroutines.
+	for _, starts := range [][]time.Time{heads, gets} {
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+		for i := 1; i < len(starts); i++ {
+			if gap := starts[i].Sub(starts[i-1]); gap < interval/2 {
+				t.Errorf("request %d of %d started %v after the one before, want about %v", i+1, len(starts), gap, interval)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DownloadRange writes the inclusive byte range start-end of the remote file
+// at url to w. The range is validated against the size reported by a HEAD
+// request, the server must answer with 206 Partial Content.
+func (d *Downloader) DownloadRange(url string, start, end int64, w io.Writer) error {
+	ctx := context.Background()
+	if start < 0 || end < start {
+		return fmt.Errorf("%w %d-%d", ErrInvalidRange, start, end)
+	}
+	req := fileRequest{url: url}
+	size, _, err := d.checkFileSizeWithHeaderContentLength(ctx, req, time.Time{})
+	if err != nil {
+		return fmt.Errorf("error while checking the size of the file: %w", err)
+	}
+	if size >= 0 && end >= size {
+		return fmt.Errorf("%w: range %d-%d is outside of the file %s of size %d", ErrInvalidRange, start, end, url, size)
+	}
+	return d.downloadFileForRange(ctx, req, -1, start, end, w, true)
+}
+
+// probeWithRange determines the size of the url of req from the Content-Range of a
+// GET request for its first byte, for servers which reject HEAD requests.
+func (d *Downloader) probeWithRange(ctx context.Context, req fileRequest, ifModifiedSince time.Time) (int64, *http.Response, error) {
+	fileUrl := req.url
+	request, err := req.newRequest(ctx, "GET", fileUrl)
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("Range", d.rangeHeader(0, 0))
+	if !ifModifiedSince.IsZero() {
+		request.Header.Set("If-Modified-Since", ifModifiedSince.UTC().Format(http.TimeFormat))
+	}
+	resp, err := d.do(request)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error while using range request for the size of the file: %s and error: %w", fileUrl, err)
+	}
+	// Closing without reading also stops servers which ignored the range.
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && !ifModifiedSince.IsZero():
+		return 0, resp, errNotModified
+	case resp.StatusCode == http.StatusPartialContent, resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// An empty file can't satisfy any range, but is sent as "bytes */0".
+		size, err := contentRangeSize(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: error while reading the size of the file %s: %v", ErrSizeUnknown, fileUrl, err)
+		}
+		return size, resp, nil
+	case resp.StatusCode == http.StatusOK:
+		// The range was ignored, the whole file is announced instead.
+		return resp.ContentLength, resp, nil
+	}
+	return 0, nil, fmt.Errorf("%w: status is :%d of range request for the file: %s", ErrUnexpectedStatus, resp.StatusCode, fileUrl)
+}
+
+// rangeComplete reports whether err is the 416 Range Not Satisfiable
+// answering a resumed range which starts at offset because earlier attempts
+// already received the whole file. A 416 announcing another length means
+// the file changed instead.
+func rangeComplete(err error, offset int64) bool {
+	var se *statusError
+	if !errors.As(err, &se) || se.code != http.StatusRequestedRangeNotSatisfiable {
+		return false
+	}
+	return se.size < 0 || se.size == offset
+}
+
+// contentRangeSize returns the complete length of a Content-Range header
+// like "bytes 0-0/1234", or -1 when the server doesn't know it ("*").
+func contentRangeSize(contentRange string) (int64, error) {
+	i := strings.LastIndex(contentRange, "/")
+	if !strings.HasPrefix(contentRange, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	total := contentRange[i+1:]
+	if total == "*" {
+		return -1, nil
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	return size, nil
+}
+
+// checkContentRange returns ErrSizeChanged when the Content-Range of a
+// partial response announces another length than want. A length unknown
+// to the server passes.
+func checkContentRange(resp *http.Response, want int64) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil
+	}
+	size, err := contentRangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if size >= 0 && size != want {
+		return fmt.Errorf("%w: %s announces %d bytes, expected %d", ErrSizeChanged, resp.Header.Get("Content-Range"), size, want)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDownloadRange(t *testing.T) {
+	data := fixture(5000)
+	srv := serve(map[string][]byte{"/f.bin": data})
+	defer srv.Close()
+	d := NewDownloader(DownloadOptions{})
+
+	tests := []struct {
+		start, end int64
+	}{
+		{0, 0},
+		{0, 15},
+		{1234, 2345},
+		{4990, 4999},
+		{0, 4999},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := d.DownloadRange(srv.URL+"/f.bin", tt.start, tt.end, &buf); err != nil {
+			t.Errorf("DownloadRange(%d, %d) error = %v", tt.start, tt.end, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), data[tt.start:tt.end+1]) {
+			t.Errorf("DownloadRange(%d, %d) got %d bytes differing from the source", tt.start, tt.end, buf.Len())
+		}
+	}
+
+	for _, tt := range []struct{ start, end int64 }{{-1, 10}, {10, 5}, {0, 5000}, {6000, 7000}} {
+		var buf bytes.Buffer
+		if err := d.DownloadRange(srv.URL+"/f.bin", tt.start, tt.end, &buf); !errors.Is(err, ErrInvalidRange) || buf.Len() != 0 {
+			t.Errorf("DownloadRange(%d, %d) = %v with %d bytes, want ErrInvalidRange", tt.start, tt.end, err, buf.Len())
+		}
+	}
+}
+
+func TestDownloadRangeIgnored(t *testing.T) {
+	// The server ignores ranges and sends the whole file.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(fixture(5000))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	if err := NewDownloader(DownloadOptions{}).DownloadRange(srv.URL+"/f.bin", 10, 20, &buf); !errors.Is(err, ErrRangeNotSupported) {
+		t.Errorf("DownloadRange() error = %v, want ErrRangeNotSupported", err)
+	}
+}
+
+func TestContentRangeSize(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    int64
+		wantErr bool
+	}{
+		{"bytes 0-0/1234", 1234, false},
+		{"bytes 100-199/200", 200, false},
+		{"bytes 0-99/*", -1, false},
+		{"bytes */1234", 1234, false},
+		{"", 0, true},
+		{"items 0-0/1234", 0, true},
+		{"bytes 0-0", 0, true},
+		{"bytes 0-0/-5", 0, true},
+		{"bytes 0-0/many", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := contentRangeSize(tt.header)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("contentRangeSize(%q) = %d, %v, want %d", tt.header, got, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyContentRange(t *testing.T) {
+	data := fixture(12 << 20)
+	// The file grows by a byte after its first part is served.
+	grown := append(append([]byte(nil), data...), 'x')
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rg := r.Header.Get("Range"); rg != "" && !strings.HasPrefix(rg, "bytes=0-") {
+			http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(grown))
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	for _, verify := range []bool{false, true} {
+		dir := t.TempDir()
+		d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 3, VerifyContentRange: verify})
+		_, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+		if !verify {
+			if err != nil {
+				t.Errorf("DownloadAll() without VerifyContentRange error = %v", err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrSizeChanged) {
+			t.Errorf("DownloadAll() error = %v, want ErrSizeChanged", err)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("the inconsistent file left %d files behind", len(entries))
+		}
+	}
+}
+
+func TestRangeHeaderFunc(t *testing.T) {
+	data := fixture(12 << 20)
+	var mu sync.Mutex
+	var got []string
+	// The server only understands "x-bytes start:end".
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rg := r.Header.Get("Range"); rg != "" {
+			mu.Lock()
+			got = append(got, rg)
+			mu.Unlock()
+			var start, end int64
+			if _, err := fmt.Sscanf(rg, "x-bytes %d:%d", &start, &end); err != nil {
+				http.Error(w, "bad range", http.StatusBadRequest)
+				return
+			}
+			r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 2, RangeHeaderFunc: func(start, end int64) string {
+		return fmt.Sprintf("x-bytes %d:%d", start, end)
+	}})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("downloaded file differs")
+	}
+	sort.Strings(got)
+	if want := []string{"x-bytes 0:6291455", "x-bytes 6291456:12582911"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("server got the ranges %q, want %q", got, want)
+	}
+
+	if got := NewDownloader(DownloadOptions{}).rangeHeader(100, -1); got != "bytes=100-" {
+		t.Errorf("default rangeHeader(100, -1) = %q, want bytes=100-", got)
+	}
+	if got := NewDownloader(DownloadOptions{}).rangeHeader(0, 99); got != "bytes=0-99" {
+		t.Errorf("default rangeHeader(0, 99) = %q, want bytes=0-99", got)
+	}
+}
+
+func TestRangeComplete(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&statusError{code: http.StatusRequestedRangeNotSatisfiable, size: 1000}, true},
+		{fmt.Errorf("error while downloading: %w", &statusError{code: http.StatusRequestedRangeNotSatisfiable, size: -1}), true},
+		// The file changed size.
+		{&statusError{code: http.StatusRequestedRangeNotSatisfiable, size: 2000}, false},
+		{&statusError{code: http.StatusInternalServerError, size: -1}, false},
+		{errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		if got := rangeComplete(tt.err, 1000); got != tt.want {
+			t.Errorf("rangeComplete(%v, 1000) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestResumeAtEndOfFile(t *testing.T) {
+	data := fixture(300000)
+	var mu sync.Mutex
+	var ranges []string
+	// The size is unknown and every GET drops the connection after sending
+	// the rest of the file, the retry starting at its end gets 416.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		var start int
+		if rg := r.Header.Get("Range"); rg != "" {
+			fmt.Sscanf(rg, "bytes=%d-", &start)
+			if start >= len(data) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", start, len(data)-1))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		w.Write(data[start:])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := noJitter(NewDownloader(DownloadOptions{Storage: storage, MaxRetries: 2}))
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("downloaded file differs")
+	}
+	mu.Lock()
+	if want := []string{"bytes=0-", "bytes=300000-"}; !reflect.DeepEqual(ranges, want) {
+		t.Errorf("got the ranges %q, want the file and a 416 at its end %q", ranges, want)
+	}
+	ranges = nil
+	mu.Unlock()
+
+	stream, err := d.Open(srv.URL + "/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	got, err := io.ReadAll(stream)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("stream has %d bytes and %v, want the file", len(got), err)
+	}
+}
@@ -0,0 +1,99 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Request is a url for DownloadEach along with settings overriding the
+// DownloadOptions of the Downloader for this url only. Zero values keep the
+// defaults.
+type Request struct {
+	URL string
+	// FileName is the local file name, instead of the one chosen by NameFunc.
+	FileName string
+	// NumConcParts overrides DownloadOptions.NumConcParts.
+	NumConcParts int
+	// Header is sent with every request for URL.
+	Header http.Header
+	// Method and Body replace the GET request for URL, e.g. for export
+	// endpoints which only answer a POST. Such urls are not probed with
+	// HEAD and are downloaded as a single stream.
+	Method string
+	Body   []byte
+}
+
+// DownloadEach is like DownloadAll for requests carrying their own
+// settings.
+func (d *Downloader) DownloadEach(ctx context.Context, requests []Request) ([]DownloadResult, error) {
+	fileRequests := make([]fileRequest, 0, len(requests))
+	for _, r := range requests {
+		req := fileRequest{url: r.URL, fileName: urlFileName(r.URL), numConcParts: r.NumConcParts, header: r.Header, method: r.Method, body: r.Body}
+		if r.FileName != "" {
+			if err := validateFileName(r.FileName); err != nil {
+				return nil, fmt.Errorf("invalid file name for %s: %w", r.URL, err)
+			}
+			req.fileName, req.named = r.FileName, true
+		}
+		fileRequests = append(fileRequests, req)
+	}
+	return d.downloadFiles(ctx, fileRequests)
+}
+
+// idempotent reports whether the requests of r may be repeated without
+// side effects, which is the case unless r has a method other than GET,
+// HEAD, PUT, DELETE, OPTIONS or TRACE.
+func (r fileRequest) idempotent() bool {
+	switch r.method {
+	case "", "GET", "HEAD", "PUT", "DELETE", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// newRequest creates a request for url, the url of r or one of its pages,
+// carrying the header of r. A GET is replaced by the method and body of r.
+func (r fileRequest) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	var body io.Reader
+	if r.hasBody() && method == "GET" {
+		if r.method != "" {
+			method = r.method
+		}
+		body = bytes.NewReader(r.body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.header {
+		request.Header[k] = append([]string(nil), v...)
+	}
+	return request, nil
+}
+
+// do applies RequestMiddleware to request and sends it with the client of
+// d.
+func (d *Downloader) do(request *http.Request) (*http.Response, error) {
+	for _, mw := range d.downloadOptions.RequestMiddleware {
+		if err := mw(request); err != nil {
+			return nil, fmt.Errorf("error while preparing the %s request for %s: %w", request.Method, request.URL, err)
+		}
+	}
+	var response *http.Response
+	sent := false
+	err := d.retryOpenFiles(request.Context(), func() (err error) {
+		if sent && request.GetBody != nil {
+			// The failed attempt may have consumed the body.
+			if request.Body, err = request.GetBody(); err != nil {
+				return err
+			}
+		}
+		sent = true
+		response, err = d.client.Do(request)
+		return err
+	})
+	return response, err
+}
@@ -0,0 +1,211 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDownloadEachOverrides(t *testing.T) {
+	content := []byte("secret content")
+	srv := serve(map[string][]byte{"/a.bin": content, "/b.bin": content})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir})
+	results, err := d.DownloadEach(context.Background(), []Request{
+		{URL: srv.URL + "/a.bin", Header: http.Header{"X-Token": {"a"}}, FileName: "renamed.bin"},
+		{URL: srv.URL + "/b.bin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "renamed.bin"); results[0].Path != want {
+		t.Errorf("Path = %q, want %q", results[0].Path, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.bin")); err != nil {
+		t.Errorf("b.bin: %v", err)
+	}
+	for path, want := range map[string]string{"/a.bin": "a", "/b.bin": ""} {
+		requests := srv.Requests(path)
+		if len(requests) == 0 {
+			t.Fatalf("no requests for %s", path)
+		}
+		for _, r := range requests {
+			if got := r.Header.Get("X-Token"); got != want {
+				t.Errorf("X-Token of %s = %q, want %q", path, got, want)
+			}
+		}
+	}
+}
+
+func TestDownloadEachPost(t *testing.T) {
+	content := []byte("exported file")
+	body := []byte(`{"format":"csv"}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || !bytes.Equal(got, body) {
+			http.Error(w, "POST the export query", http.StatusBadRequest)
+			return
+		}
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir})
+	results, err := d.DownloadEach(context.Background(), []Request{
+		{URL: srv.URL + "/export", Method: http.MethodPost, Body: body, FileName: "export.csv"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(results[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("content = %q, want %q", got, content)
+	}
+
+	if _, err := d.DownloadEach(context.Background(), []Request{{URL: srv.URL + "/export", FileName: "get.csv"}}); err == nil {
+		t.Fatal("DownloadEach() of a GET succeeded, want the 400 error")
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		method string
+		want   bool
+	}{
+		{"", true},
+		{"GET", true},
+		{"PUT", true},
+		{"POST", false},
+		{"PATCH", false},
+	}
+	for _, tt := range tests {
+		if got := (fileRequest{method: tt.method}).idempotent(); got != tt.want {
+			t.Errorf("idempotent() of %q = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	// The first request of every method fails.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.Method]++
+		n := calls[r.Method]
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("export"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		method    string
+		opt       bool
+		wantCalls int
+	}{
+		{"POST", false, 1},
+		{"POST", true, 2},
+		{"PATCH", false, 1},
+		{"PUT", false, 2},
+		{"GET", false, 2},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		calls = make(map[string]int)
+		mu.Unlock()
+		d := noJitter(NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), MaxRetries: 2, RetryNonIdempotent: tt.opt}))
+		_, err := d.DownloadEach(context.Background(), []Request{{URL: srv.URL + "/export", Method: tt.method, Body: []byte("q")}})
+		mu.Lock()
+		got := calls[tt.method]
+		mu.Unlock()
+		if got != tt.wantCalls || (err == nil) != (tt.wantCalls == 2) {
+			t.Errorf("%s with RetryNonIdempotent %v sent %d requests, error %v, want %d", tt.method, tt.opt, got, err, tt.wantCalls)
+		}
+	}
+}
+
+func TestRequestMiddleware(t *testing.T) {
+	data := fixture(12 << 20)
+	var mu sync.Mutex
+	var unsigned, requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		if r.Header.Get("X-Signature") != "signed "+r.Method {
+			mu.Lock()
+			unsigned++
+			mu.Unlock()
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	var order []string
+	sign := func(r *http.Request) error {
+		mu.Lock()
+		order = append(order, "sign")
+		mu.Unlock()
+		r.Header.Set("X-Signature", "signed")
+		return nil
+	}
+	method := func(r *http.Request) error {
+		mu.Lock()
+		order = append(order, "method")
+		mu.Unlock()
+		// Runs after sign and sees its header.
+		r.Header.Set("X-Signature", r.Header.Get("X-Signature")+" "+r.Method)
+		return nil
+	}
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 3, RequestMiddleware: []func(*http.Request) error{sign, method}})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("downloaded file differs")
+	}
+	mu.Lock()
+	// The size probe and the 3 parts.
+	if requests != 4 || unsigned != 0 {
+		t.Errorf("got %d requests, %d unsigned, want the probe and 3 parts signed", requests, unsigned)
+	}
+	for i := range order {
+		if want := []string{"sign", "method"}[i%2]; order[i] != want {
+			t.Fatalf("middleware order = %q, want sign before method", order)
+		}
+	}
+	requests = 0
+	mu.Unlock()
+
+	denied := errors.New("denied")
+	deny := func(r *http.Request) error { return denied }
+	d = NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), RequestMiddleware: []func(*http.Request) error{sign, deny}})
+	if _, err := d.Download(srv.URL + "/f.bin"); !errors.Is(err, denied) {
+		t.Errorf("Download() error = %v, want the error of the middleware", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 0 {
+		t.Errorf("%d rejected requests were sent", requests)
+	}
+}
@@ -0,0 +1,109 @@
+package download
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// dialFunc opens a connection like net.Dialer.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dial and DialContext make a dialFunc the forward dialer of a SOCKS5
+// proxy.Dialer.
+func (f dialFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+func (f dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// newDialer returns the DialContext of the transport: the DialContext of
+// opts or a dialer like the one of http.DefaultTransport using Resolver,
+// behind a dnsCache when DNSCacheTTL is set.
+func newDialer(opts DownloadOptions) dialFunc {
+	dial := dialFunc(opts.DialContext)
+	if dial == nil {
+		d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: opts.Resolver}
+		dial = d.DialContext
+	}
+	if opts.DNSCacheTTL <= 0 {
+		return dial
+	}
+	resolver := opts.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	c := &dnsCache{resolver: resolver, ttl: opts.DNSCacheTTL, entries: make(map[string]dnsEntry)}
+	return c.dialer(dial)
+}
+
+// dnsCache keeps the addresses of looked up hosts for ttl, so the
+// connections of a batch to one host share a single lookup.
+type dnsCache struct {
+	resolver *net.Resolver
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// lookup returns the addresses of host for network, "ip", "ip4" or "ip6".
+// Failed lookups are not cached.
+func (c *dnsCache) lookup(ctx context.Context, network, host string) ([]net.IP, error) {
+	key := network + " " + host
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.ips, nil
+	}
+
+	ips, err := c.resolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[key] = dnsEntry{ips: ips, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return ips, nil
+}
+
+// dialer returns a dialFunc resolving host names through c and dialing
+// their addresses in turn with dial until one connects.
+func (c *dnsCache) dialer(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		ipNetwork := "ip"
+		switch network {
+		case "tcp4", "udp4":
+			ipNetwork = "ip4"
+		case "tcp6", "udp6":
+			ipNetwork = "ip6"
+		}
+		ips, err := c.lookup(ctx, ipNetwork, host)
+		if err != nil {
+			return nil, err
+		}
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		for _, ip := range ips {
+			var conn net.Conn
+			conn, err = dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil || ctx.Err() != nil {
+				return conn, err
+			}
+		}
+		return nil, err
+	}
+}
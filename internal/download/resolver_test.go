@@ -0,0 +1,137 @@
+package download
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDNS serves A records of 127.0.0.1 for every name and returns a
+// Resolver querying it along with the number of queries it sent.
+func fakeDNS(t *testing.T) (*net.Resolver, *int32) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q := buf[:n]
+			// The question is the name after the 12 byte header, then its
+			// type and class.
+			i := 12
+			for i < n && q[i] != 0 {
+				i += int(q[i]) + 1
+			}
+			if i+5 > n {
+				continue
+			}
+			qtype := int(q[i+1])<<8 | int(q[i+2])
+			var answers byte
+			if qtype == 1 {
+				answers = 1
+			}
+			resp := append([]byte(nil), q[:2]...)
+			resp = append(resp, 0x81, 0x80, 0, 1, 0, answers, 0, 0, 0, 0)
+			resp = append(resp, q[12:i+5]...)
+			if answers == 1 {
+				resp = append(resp, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+			}
+			pc.WriteTo(resp, addr)
+		}
+	}()
+	var queries int32
+	r := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		atomic.AddInt32(&queries, 1)
+		var d net.Dialer
+		return d.DialContext(ctx, "udp", pc.LocalAddr().String())
+	}}
+	return r, &queries
+}
+
+func TestResolver(t *testing.T) {
+	srv := serve(map[string][]byte{"/a.bin": fixture(100), "/b.bin": fixture(200), "/c.bin": fixture(300)})
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	base := "http://files.example.test:" + port
+
+	// The queries of a single lookup.
+	r, queries := fakeDNS(t)
+	if ips, err := r.LookupIP(context.Background(), "ip", "files.example.test"); err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("LookupIP() with the fake DNS = %v, %v", ips, err)
+	}
+	perLookup := atomic.LoadInt32(queries)
+
+	tests := []struct {
+		ttl     time.Duration
+		lookups int32
+	}{
+		// The HEAD and GET of every file open a connection and look up
+		// the host.
+		{0, 3 * 2},
+		{time.Minute, 1},
+	}
+	for _, tt := range tests {
+		r, queries := fakeDNS(t)
+		// Without keep-alives every request needs a new connection. The
+		// files go one at a time, concurrent lookups of a host are merged.
+		d := NewDownloader(DownloadOptions{Storage: newMemStorage(), Resolver: r, DNSCacheTTL: tt.ttl, DisableKeepAlives: true, MaxLimitConcurrency: 1})
+		if _, err := d.Download(base+"/a.bin", base+"/b.bin", base+"/c.bin"); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt32(queries); got != tt.lookups*perLookup {
+			t.Errorf("DNSCacheTTL %v sent %d queries, want %d lookups of %d queries", tt.ttl, got, tt.lookups, perLookup)
+		}
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	r, queries := fakeDNS(t)
+	c := &dnsCache{resolver: r, ttl: 50 * time.Millisecond, entries: make(map[string]dnsEntry)}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := c.lookup(ctx, "ip4", "files.example.test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	once := atomic.LoadInt32(queries)
+	if once == 0 {
+		t.Fatal("the first lookup sent no query")
+	}
+	// Another network is another entry.
+	c.lookup(ctx, "ip", "files.example.test")
+	afterIP := atomic.LoadInt32(queries)
+	if afterIP == once {
+		t.Error("the lookup of another network was served from the cache")
+	}
+	// Entries expire after ttl.
+	time.Sleep(60 * time.Millisecond)
+	c.lookup(ctx, "ip4", "files.example.test")
+	if atomic.LoadInt32(queries) == afterIP {
+		t.Error("the expired entry was served from the cache")
+	}
+
+	// IP addresses are dialed without a lookup.
+	var dialed string
+	dial := c.dialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		return nil, nil
+	})
+	before := atomic.LoadInt32(queries)
+	dial(ctx, "tcp", "127.0.0.2:80")
+	if dialed != "127.0.0.2:80" || atomic.LoadInt32(queries) != before {
+		t.Errorf("dialing an IP address dialed %q after %d queries", dialed, atomic.LoadInt32(queries)-before)
+	}
+	dial(ctx, "tcp", "files.example.test:80")
+	if dialed != "127.0.0.1:80" {
+		t.Errorf("dialing a host name dialed %q, want its looked up address", dialed)
+	}
+}
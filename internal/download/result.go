@@ -0,0 +1,90 @@
+package download
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// errNotModified is returned by the size probe when the server replied 304
+// Not Modified to an If-Modified-Since request.
+var errNotModified = errors.New("not modified")
+
+// Status tells why a DownloadResult has the file it points to.
+type Status int
+
+const (
+	// StatusFailed is the status of results with an Err.
+	StatusFailed Status = iota
+	// StatusDownloaded is set when the file was downloaded.
+	StatusDownloaded
+	// StatusSkipped is set when an existing local file was kept, see
+	// DownloadOptions.SkipExisting.
+	StatusSkipped
+	// StatusCached is set when the local copy was kept because the server
+	// reported it as not modified, see DownloadOptions.SkipIfUnmodified.
+	StatusCached
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusFailed:
+		return "Failed"
+	case StatusDownloaded:
+		return "Downloaded"
+	case StatusSkipped:
+		return "Skipped"
+	case StatusCached:
+		return "Cached"
+	}
+	return "Status(" + strconv.Itoa(int(s)) + ")"
+}
+
+// DownloadResult is the outcome of downloading a single url.
+type DownloadResult struct {
+	URL    string
+	Status Status
+	// Path is the local path of the downloaded file, or its name when the
+	// Storage has no local paths.
+	Path string
+	// Size is the number of bytes written.
+	Size int64
+	// BytesSkipped are the bytes of Size reused from an earlier attempt with
+	// DownloadOptions.Resume and BytesDownloaded the bytes fetched by this
+	// one.
+	BytesDownloaded int64
+	BytesSkipped    int64
+	// Concurrency is the number of parts the file was downloaded in, or the
+	// peak concurrency reached with DownloadOptions.AutoTune.
+	Concurrency int
+	Duration    time.Duration
+	// Cached is set when an existing local copy was kept because the server
+	// reported it as not modified.
+	Cached bool
+	// Hash is the hex digest of the file, see DownloadOptions.ComputeHash.
+	Hash string
+	// Headers are the headers of the HEAD response the size of the file
+	// was taken from, nil for ftp urls and requests with a body.
+	Headers http.Header
+	// Extracted are the paths of the files extracted from the archive at
+	// Path, see DownloadOptions.ExtractArchives.
+	Extracted []string
+	// ExtractErr is the error extracting the archive at Path. The file
+	// itself was downloaded, Err stays nil.
+	ExtractErr error
+	Err        error
+}
+
+// finishResult fills in the fields of result common to every kind of download.
+func finishResult(result DownloadResult, fileUrl string, start time.Time, err error) DownloadResult {
+	result.URL = fileUrl
+	result.Duration = time.Since(start)
+	result.Err = err
+	result.Status = StatusDownloaded
+	result.BytesDownloaded = result.Size - result.BytesSkipped
+	if err != nil {
+		result.Status = StatusFailed
+	}
+	return result
+}
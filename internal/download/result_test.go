@@ -0,0 +1,109 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResultStatus(t *testing.T) {
+	srv := serve(map[string][]byte{"/new.bin": fixture(100), "/existing.bin": fixture(200), "/cached.bin": fixture(300)})
+	defer srv.Close()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "existing.bin"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// cached.bin is newer than the served one, which answers 304.
+	cached := filepath.Join(dir, "cached.bin")
+	if err := os.WriteFile(cached, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(cached, fixedModTime.Add(time.Hour), fixedModTime.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, SkipExisting: true})
+	results, _ := d.DownloadAll(context.Background(), srv.URL+"/new.bin", srv.URL+"/existing.bin", srv.URL+"/missing.bin")
+	d = NewDownloader(DownloadOptions{DownloadDir: dir, SkipIfUnmodified: true})
+	more, err := d.DownloadAll(context.Background(), srv.URL+"/cached.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = append(results, more...)
+
+	want := []Status{StatusDownloaded, StatusSkipped, StatusFailed, StatusCached}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("Status of %s = %v, want %v", r.URL, r.Status, want[i])
+		}
+		if (r.Err != nil) != (r.Status == StatusFailed) {
+			t.Errorf("result of %s with Status %v has the error %v", r.URL, r.Status, r.Err)
+		}
+	}
+	// Kept files point to the local copy.
+	if r := results[1]; r.Path != filepath.Join(dir, "existing.bin") {
+		t.Errorf("Path of the skipped file = %q", r.Path)
+	}
+	if r := results[3]; r.Path != cached || !r.Cached {
+		t.Errorf("cached result = %+v, want the local copy", r)
+	}
+}
+
+func TestStatusString(t *testing.T) {
+	tests := map[Status]string{
+		StatusFailed:     "Failed",
+		StatusDownloaded: "Downloaded",
+		StatusSkipped:    "Skipped",
+		StatusCached:     "Cached",
+		Status(9):        "Status(9)",
+	}
+	for s, want := range tests {
+		if got := s.String(); got != want {
+			t.Errorf("Status(%d).String() = %q, want %q", int(s), got, want)
+		}
+	}
+}
+
+func TestResultHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.ServeContent(w, r, "x.txt", fixedModTime, bytes.NewReader([]byte("hello")))
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage()})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/x.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := results[0].Headers
+	want := map[string]string{
+		"ETag":          `"v1"`,
+		"Cache-Control": "max-age=60",
+		"Last-Modified": fixedModTime.UTC().Format(http.TimeFormat),
+		"Content-Type":  "text/plain; charset=utf-8",
+	}
+	for k, v := range want {
+		if got := h.Get(k); got != v {
+			t.Errorf("Headers[%s] = %q, want %q", k, got, v)
+		}
+	}
+
+	// Requests with a body are not probed, ftp urls have no headers.
+	d.ftp = &fakeFTP{files: map[string][]byte{"/f.bin": []byte("ftp")}}
+	results, err = d.DownloadEach(context.Background(), []Request{{URL: srv.URL + "/post.txt", Method: http.MethodPost, Body: []byte("q")}, {URL: "ftp://example.com/f.bin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Headers != nil {
+			t.Errorf("Headers of %s = %v, want none", r.URL, r.Headers)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// partManifest is the sidecar of a resumable download. It records the remote
+// file the partial file belongs to, its parts and which of them completed.
+type partManifest struct {
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+	// ETag and LastModified are the validators of the remote file, a
+	// manifest is only reused while they are unchanged.
+	ETag         string         `json:"etag,omitempty"`
+	LastModified string         `json:"last_modified,omitempty"`
+	Parts        []manifestPart `json:"parts"`
+}
+
+type manifestPart struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  bool  `json:"done,omitempty"`
+	// SHA256 is the hex digest of the part, set once the part is complete
+	// when downloading with VerifyParts.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// matches reports whether m was written for the same version of the remote
+// file as current.
+func (m partManifest) matches(current partManifest) bool {
+	if m.URL != current.URL || m.Size != current.Size || len(m.Parts) == 0 {
+		return false
+	}
+	if m.ETag != "" || current.ETag != "" {
+		return m.ETag == current.ETag
+	}
+	return m.LastModified == current.LastModified
+}
+
+// doneSize returns how many bytes the partial file of m must hold at least,
+// the end of the last part recorded as done.
+func (m partManifest) doneSize() int64 {
+	var size int64
+	for _, p := range m.Parts {
+		if (p.Done || p.SHA256 != "") && p.End+1 > size {
+			size = p.End + 1
+		}
+	}
+	return size
+}
+
+// resumeState guards the manifest of a resumable download while its parts
+// complete concurrently.
+type resumeState struct {
+	mu     sync.Mutex
+	path   string
+	verify bool
+	// manifest is guarded by mu.
+	manifest partManifest
+}
+
+// resumeNames returns the names of the partial file and of the manifest of
+// a resumable download of name.
+func resumeNames(name string) (partialName, manifestName string) {
+	return hiddenName(name, ".partial"), hiddenName(name, ".parts.json")
+}
+
+// openResumable opens the partial file of a resumable download of
+// contentLength bytes of the url of req along with its manifest. A manifest
+// left by an earlier download of the same url, size and validators in
+// header is reused, otherwise a new one is planned from ranges. It returns
+// a nil file when the storage is not a LocalStorage.
+func (d *Downloader) openResumable(req fileRequest, fileName string, header http.Header, replace bool, contentLength int64, ranges []byteRange) (*outputFile, *resumeState, error) {
+	url := req.url
+	local, ok := d.storage(req).(LocalStorage)
+	if !ok {
+		return nil, nil, nil
+	}
+	name, err := d.cleanName(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !replace && local.Exists(name) {
+		return nil, nil, fmt.Errorf("%w : %s", ErrFileExists, name)
+	}
+
+	partialName, manifestName := resumeNames(name)
+	suffixed, err := d.inProgressName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if suffixed != "" {
+		partialName = suffixed
+	}
+	partialPath, err := safePath(local.Dir, partialName)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifestPath, err := safePath(local.Dir, manifestName)
+	if err != nil {
+		return nil, nil, err
+	}
+	path, err := safePath(local.Dir, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	current := partManifest{URL: url, Size: contentLength, ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+	state := &resumeState{path: manifestPath, verify: d.downloadOptions.VerifyParts}
+	// A partial file which was removed or truncated no longer holds the
+	// parts done, the manifest is only reused while it does.
+	if m, err := readManifest(manifestPath); err == nil && m.matches(current) && partialHolds(partialPath, m.doneSize()) {
+		state.manifest = m
+	} else {
+		state.manifest = current
+		for _, r := range ranges {
+			state.manifest.Parts = append(state.manifest.Parts, manifestPart{Start: r.start, End: r.end})
+		}
+	}
+
+	if err := local.mkdir(filepath.Dir(partialPath)); err != nil {
+		return nil, nil, fmt.Errorf("error while creating the download directory: %w", err)
+	}
+	f, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, local.fileMode())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while creating file %s: %w", partialPath, err)
+	}
+	out := &outputFile{WriteCloser: f, storage: local, name: name, tempName: partialName, path: path, keep: true}
+	return out, state, nil
+}
+
+// partialHolds reports whether the partial file at path has at least size
+// bytes.
+func partialHolds(path string, size int64) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Size() >= size
+}
+
+func readManifest(path string) (partManifest, error) {
+	var m partManifest
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// ranges returns the byte ranges of the parts recorded in the manifest.
+func (r *resumeState) ranges() []byteRange {
+	ranges := make([]byteRange, len(r.manifest.Parts))
+	for i, p := range r.manifest.Parts {
+		ranges[i] = byteRange{start: p.Start, end: p.End}
+	}
+	return ranges
+}
+
+// done reports whether part i was completed by an earlier download. With
+// VerifyParts its bytes in f must also still match the recorded checksum.
+func (r *resumeState) done(f io.ReaderAt, i int) bool {
+	p := r.manifest.Parts[i]
+	if !r.verify {
+		return p.Done || p.SHA256 != ""
+	}
+	if p.SHA256 == "" {
+		return false
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, p.Start, p.End-p.Start+1)); err != nil {
+		log.Printf("error while verifying part %d of %s: %v", i, r.path, err)
+		return false
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != p.SHA256 {
+		log.Printf("part %d of %s failed verification, downloading it again", i, r.path)
+		return false
+	}
+	return true
+}
+
+// complete marks part i as done, records its checksum unless sum is nil and
+// saves the manifest.
+func (r *resumeState) complete(i int, sum []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifest.Parts[i].Done = true
+	if sum != nil {
+		r.manifest.Parts[i].SHA256 = hex.EncodeToString(sum)
+	}
+
+	b, err := json.Marshal(r.manifest)
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0666); err != nil {
+		return fmt.Errorf("error while writing manifest %s: %w", r.path, err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("error while writing manifest %s: %w", r.path, err)
+	}
+	return nil
+}
+
+// remove deletes the manifest once the download is complete.
+func (r *resumeState) remove() {
+	os.Remove(r.path)
+}
+
+// stagedPartPath returns the stable path of part i of parts of a file
+// staged in dir because its Storage can't be written at offsets. The name
+// hashes the url, size and validators, so parts of another version of the
+// file are never reused.
+func stagedPartPath(dir, name, url string, header http.Header, size int64, parts, i int) string {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	key := fmt.Sprintf("%s\n%d\n%s\n%s\n%d", url, size, header.Get("ETag"), header.Get("Last-Modified"), parts)
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, "."+name+"."+hex.EncodeToString(sum[:4])+"."+strconv.Itoa(i)+".part")
+}
+
+// stagedSize returns how many bytes of a part of length bytes the staged
+// part file f already holds. A file longer than the part is emptied.
+func stagedSize(f *os.File, length int64) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("error while checking part %s: %w", f.Name(), err)
+	}
+	if fi.Size() <= length {
+		return fi.Size(), nil
+	}
+	if err := f.Truncate(0); err != nil {
+		return 0, fmt.Errorf("error while emptying part %s: %w", f.Name(), err)
+	}
+	return 0, nil
+}
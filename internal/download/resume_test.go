@@ -0,0 +1,406 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anupam111/concurrent-downloader/internal/download/internal/testserver"
+)
+
+// rangeServer serves data for every path. GETs of ranges starting at failAt
+// fail once release is closed, while failAt is set.
+type rangeServer struct {
+	*testserver.FileServer
+	mu      sync.Mutex
+	failAt  string
+	release chan struct{}
+}
+
+func newRangeServer(data []byte) *rangeServer {
+	s := &rangeServer{FileServer: newServer(), release: make(chan struct{})}
+	s.AddAll(data)
+	s.FailFunc = func(r *http.Request) *testserver.Failure {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Method == http.MethodGet && s.failAt != "" && strings.HasPrefix(r.Header.Get("Range"), "bytes="+s.failAt+"-") {
+			return &testserver.Failure{Status: http.StatusInternalServerError, Wait: s.release}
+		}
+		return nil
+	}
+	return s
+}
+
+// requested returns the sorted ranges of the GETs so far and resets them.
+func (s *rangeServer) requested() []string {
+	ranges := s.Ranges()
+	s.Reset()
+	sort.Strings(ranges)
+	return ranges
+}
+
+func TestResumeManifest(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := newRangeServer(data)
+	defer srv.Close()
+	per := int64(len(data) / 3)
+	lastModified := fixedModTime.UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		manifest partManifest
+		want     []string
+	}{
+		{"missing part", partManifest{LastModified: lastModified}, []string{"bytes=4194304-8388607"}},
+		// A manifest of another version of the file is not reused.
+		{"changed file", partManifest{LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}, []string{"bytes=0-4194303", "bytes=4194304-8388607", "bytes=8388608-12582911"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.manifest
+			m.URL, m.Size = srv.URL+"/f.bin", int64(len(data))
+			m.Parts = []manifestPart{{Start: 0, End: per - 1, Done: true}, {Start: per, End: 2*per - 1}, {Start: 2 * per, End: int64(len(data)) - 1, Done: true}}
+			b, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The partial file holds the done parts, the middle one is zero.
+			partial := make([]byte, len(data))
+			copy(partial, data[:per])
+			copy(partial[2*per:], data[2*per:])
+			dir := t.TempDir()
+			partialName, manifestName := resumeNames("f.bin")
+			if err := os.WriteFile(filepath.Join(dir, manifestName), b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, partialName), partial, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			srv.requested()
+			d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 3, Resume: true})
+			if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+				t.Fatal(err)
+			}
+			if got := srv.requested(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("resume requested %q, want %q", got, tt.want)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "f.bin"))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("resumed file has %d bytes and %v, want the served file", len(got), err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("download dir has %d files, want the partial file and manifest removed", len(entries))
+			}
+		})
+	}
+}
+
+func TestResumeAfterFailure(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := newRangeServer(data)
+	defer srv.Close()
+	// The last of the 4 parts fails once a part is recorded as done.
+	srv.failAt = "9437184"
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 4, Resume: true})
+	var partsDone int
+	for ev := range d.DownloadStream(context.Background(), srv.URL+"/f.bin") {
+		if ev.Type == PartDone {
+			if partsDone++; partsDone == 1 {
+				close(srv.release)
+			}
+		}
+		if ev.Type == FileDone {
+			t.Fatal("DownloadStream() with a failing part succeeded")
+		}
+	}
+	_, manifestName := resumeNames("f.bin")
+	m, err := readManifest(filepath.Join(dir, manifestName))
+	if err != nil {
+		t.Fatalf("the failed download left no manifest: %v", err)
+	}
+	if len(m.Parts) != 4 || m.Parts[3].Done || (m.ETag == "" && m.LastModified == "") {
+		t.Errorf("manifest = %+v, want 4 parts with the last one not done", m)
+	}
+
+	srv.requested()
+	srv.failAt = ""
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	// The parts cancelled by the failure are fetched again too.
+	for _, r := range srv.requested() {
+		for i, p := range m.Parts {
+			if p.Done && r == fmt.Sprintf("bytes=%d-%d", p.Start, p.End) {
+				t.Errorf("resume requested part %d again, it was done", i)
+			}
+		}
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "f.bin"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("resumed file has %d bytes and %v, want the served file", len(got), err)
+	}
+}
+
+func TestResumeLostPartial(t *testing.T) {
+	data := fixture(12 << 20)
+	partialName, manifestName := resumeNames("f.bin")
+	tests := []struct {
+		name string
+		lose func(path string) error
+	}{
+		{"removed", os.Remove},
+		{"truncated", func(path string) error { return os.Truncate(path, 1<<20) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newRangeServer(data)
+			defer srv.Close()
+			// The last of the 4 parts fails once the others are done.
+			srv.failAt = "9437184"
+
+			dir := t.TempDir()
+			d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 4, Resume: true})
+			var partsDone int
+			for ev := range d.DownloadStream(context.Background(), srv.URL+"/f.bin") {
+				if ev.Type == PartDone {
+					if partsDone++; partsDone == 3 {
+						close(srv.release)
+					}
+				}
+			}
+			if _, err := readManifest(filepath.Join(dir, manifestName)); err != nil {
+				t.Fatalf("the failed download left no manifest: %v", err)
+			}
+			if err := tt.lose(filepath.Join(dir, partialName)); err != nil {
+				t.Fatal(err)
+			}
+
+			srv.requested()
+			srv.failAt = ""
+			if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+				t.Fatal(err)
+			}
+			// All parts are downloaded again.
+			if got := srv.requested(); len(got) != 4 {
+				t.Errorf("resume requested %q, want all 4 parts", got)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "f.bin"))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("resumed file has %d bytes and %v, want the served file", len(got), err)
+			}
+		})
+	}
+}
+
+func TestVerifyPartsRedownloadsCorruptPart(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := newRangeServer(data)
+	defer srv.Close()
+	// The last of the 4 parts fails once the others are recorded as done.
+	srv.failAt = "9437184"
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 4, VerifyParts: true})
+	var partsDone int
+	for ev := range d.DownloadStream(context.Background(), srv.URL+"/f.bin") {
+		if ev.Type == PartDone {
+			if partsDone++; partsDone == 3 {
+				close(srv.release)
+			}
+		}
+		if ev.Type == FileDone {
+			t.Fatal("DownloadStream() with a failing part succeeded")
+		}
+	}
+	srv.requested()
+
+	// Corrupt the first part on disk.
+	partialName, _ := resumeNames("f.bin")
+	f, err := os.OpenFile(filepath.Join(dir, partialName), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("corrupt"), 100); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	srv.failAt = ""
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.requested(), []string{"bytes=0-3145727", "bytes=9437184-12582911"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("resume requested %q, want only the corrupt and the failed part %q", got, want)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "f.bin"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("resumed file has %d bytes and %v, want the served file", len(got), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("download dir has %d files, want the partial file and manifest removed", len(entries))
+	}
+}
+
+// failingCombineStorage is a memStorage whose files fail to be written
+// while fail is set, like a combine interrupted by a full disk.
+type failingCombineStorage struct {
+	*memStorage
+	fail bool
+}
+
+func (s *failingCombineStorage) Create(name string) (io.WriteCloser, error) {
+	w, err := s.memStorage.Create(name)
+	if s.fail {
+		return struct {
+			io.Writer
+			io.Closer
+		}{failingWriter{}, w}, err
+	}
+	return w, err
+}
+
+func TestResumeStagedParts(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := newRangeServer(data)
+	defer srv.Close()
+
+	// All 4 parts are downloaded, then the combine fails.
+	tmp := t.TempDir()
+	storage := &failingCombineStorage{memStorage: newMemStorage(), fail: true}
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: tmp, NumConcParts: 4, Resume: true})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err == nil {
+		t.Fatal("DownloadAll() with a failing combine succeeded")
+	}
+	if got := srv.requested(); len(got) != 4 {
+		t.Fatalf("got the ranges %q, want 4 parts", got)
+	}
+	entries, _ := os.ReadDir(tmp)
+	if len(entries) != 4 {
+		t.Fatalf("temp dir has %d files, want the 4 staged parts kept", len(entries))
+	}
+	if storage.file("f.bin") != nil {
+		t.Fatal("the failed combine left f.bin")
+	}
+
+	// The rerun only combines the complete parts.
+	storage.fail = false
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.requested(); len(got) != 0 {
+		t.Errorf("resume requested %q, want only the combine", got)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("combined file differs")
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("temp dir has %d files after the combine, want the staged parts removed", len(entries))
+	}
+}
+
+func TestResumeShortStagedPart(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := newRangeServer(data)
+	defer srv.Close()
+
+	tmp := t.TempDir()
+	storage := &failingCombineStorage{memStorage: newMemStorage(), fail: true}
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: tmp, NumConcParts: 4, Resume: true})
+	d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	srv.requested()
+
+	// Part 1 was cut short, it is resumed from where it ends.
+	header := http.Header{"Last-Modified": {fixedModTime.UTC().Format(http.TimeFormat)}}
+	part := stagedPartPath(tmp, "f.bin", srv.URL+"/f.bin", header, int64(len(data)), 4, 1)
+	if err := os.Truncate(part, 1000); err != nil {
+		t.Fatal(err)
+	}
+	storage.fail = false
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.requested(), []string{fmt.Sprintf("bytes=%d-%d", 3<<20+1000, 6<<20-1)}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("resume requested %q, want only the rest of part 1 %q", got, want)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("combined file differs")
+	}
+}
+
+func TestResumeSkippedBytes(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := newRangeServer(data)
+	defer srv.Close()
+	// The last of the 4 parts fails once the others are done.
+	srv.failAt = "9437184"
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 4, Resume: true})
+	var partsDone int
+	for ev := range d.DownloadStream(context.Background(), srv.URL+"/f.bin") {
+		if ev.Type == PartDone {
+			if partsDone++; partsDone == 3 {
+				close(srv.release)
+			}
+		}
+		if ev.Type == FileDone {
+			t.Fatal("DownloadStream() with a failing part succeeded")
+		}
+	}
+
+	srv.failAt = ""
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := results[0]
+	if r.BytesSkipped != 9<<20 || r.BytesDownloaded != 3<<20 || r.Size != int64(len(data)) {
+		t.Errorf("resumed %d bytes: skipped %d, downloaded %d, want 3 parts skipped and 1 downloaded", r.Size, r.BytesSkipped, r.BytesDownloaded)
+	}
+
+	// A fresh download skips nothing.
+	results, err = NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), NumConcParts: 4, Resume: true}).DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; r.BytesSkipped != 0 || r.BytesDownloaded != int64(len(data)) {
+		t.Errorf("fresh download skipped %d and downloaded %d bytes, want all downloaded", r.BytesSkipped, r.BytesDownloaded)
+	}
+}
+
+func TestResumeSkippedStagedBytes(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := newRangeServer(data)
+	defer srv.Close()
+
+	tmp := t.TempDir()
+	storage := &failingCombineStorage{memStorage: newMemStorage(), fail: true}
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: tmp, NumConcParts: 4, Resume: true})
+	d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+
+	// Part 1 keeps only its first 1000 bytes.
+	header := http.Header{"Last-Modified": {fixedModTime.UTC().Format(http.TimeFormat)}}
+	if err := os.Truncate(stagedPartPath(tmp, "f.bin", srv.URL+"/f.bin", header, int64(len(data)), 4, 1), 1000); err != nil {
+		t.Fatal(err)
+	}
+	storage.fail = false
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, missing := results[0], int64(3<<20-1000); r.BytesDownloaded != missing || r.BytesSkipped != int64(len(data))-missing {
+		t.Errorf("skipped %d and downloaded %d bytes, want %d downloaded", r.BytesSkipped, r.BytesDownloaded, missing)
+	}
+}
@@ -0,0 +1,242 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// retryBackoff is the longest wait before the first retry of a failed
+// range. It doubles with every further retry, up to maxRetryBackoff.
+const (
+	retryBackoff    = 500 * time.Millisecond
+	maxRetryBackoff = 30 * time.Second
+)
+
+// statusError is returned when a range request is answered with a status
+// code other than 200 or 206.
+type statusError struct {
+	code int
+	// retryAfter is the wait asked for by the Retry-After header of a 429
+	// or 503 response, zero without one.
+	retryAfter time.Duration
+	// size is the length of the file announced by the Content-Range of a
+	// 416 response, -1 without one.
+	size int64
+	// body is the start of the response body, which often explains the
+	// error.
+	body string
+}
+
+// maxErrorBody is how many bytes of an error response body a statusError
+// keeps.
+const maxErrorBody = 1024
+
+// newStatusError returns the statusError of resp, reading the start of its
+// body.
+func newStatusError(resp *http.Response) *statusError {
+	e := &statusError{code: resp.StatusCode, size: -1}
+	if resp.Body != nil {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody+1))
+		cut := len(b) > maxErrorBody
+		if cut {
+			b = b[:maxErrorBody]
+		}
+		e.body = strings.TrimSpace(strings.ToValidUTF8(string(b), ""))
+		if cut {
+			e.body += "..."
+		}
+	}
+	switch e.code {
+	case 429, 503:
+		e.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	case http.StatusRequestedRangeNotSatisfiable:
+		if size, err := contentRangeSize(resp.Header.Get("Content-Range")); err == nil {
+			e.size = size
+		}
+	}
+	return e
+}
+
+// parseRetryAfter parses a Retry-After value in seconds or as an HTTP date
+// relative to now. It returns zero for missing, malformed or past values.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		if secs > int64(math.MaxInt64/time.Second) {
+			return math.MaxInt64
+		}
+		return time.Duration(secs) * time.Second
+	}
+	t, err := http.ParseTime(v)
+	if err != nil || !t.After(now) {
+		return 0
+	}
+	return t.Sub(now)
+}
+
+func (e *statusError) Error() string {
+	if e.body != "" {
+		return fmt.Sprintf("Did not get 20X status code, got : %v: %s", e.code, e.body)
+	}
+	return fmt.Sprintf("Did not get 20X status code, got : %v", e.code)
+}
+
+func (e *statusError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+// retryable reports whether a range which failed with err is worth another
+// attempt. Network errors, stalls and 429 or 5xx responses are, nothing is
+// once ctx is done.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == 429 || se.code >= 500
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrStalled)
+}
+
+// interrupted reports whether err is a connection lost midway through a
+// response body, reset by the peer or cut short.
+func interrupted(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns the longest wait before retry number attempt, counting
+// from 0.
+func backoff(attempt int) time.Duration {
+	wait := retryBackoff
+	for i := 0; i < attempt && wait < maxRetryBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxRetryBackoff {
+		wait = maxRetryBackoff
+	}
+	return wait
+}
+
+// retryWait returns the wait before retry number attempt of a range which
+// failed with err: the Retry-After of the server up to MaxRetryAfter, or a
+// random backoff.
+func (d *Downloader) retryWait(err error, attempt int) time.Duration {
+	var se *statusError
+	if errors.As(err, &se) && se.retryAfter > 0 {
+		max := d.downloadOptions.MaxRetryAfter
+		if max <= 0 {
+			max = maxRetryBackoff
+		}
+		if se.retryAfter > max {
+			return max
+		}
+		return se.retryAfter
+	}
+	return d.jitter(backoff(attempt))
+}
+
+// newJitter returns a func picking a random wait between 0 and its argument
+// from src, so parts failing together don't retry in lockstep. The func is
+// safe for concurrent use.
+func newJitter(src rand.Source) func(time.Duration) time.Duration {
+	var mu sync.Mutex
+	r := rand.New(src)
+	return func(d time.Duration) time.Duration {
+		if d <= 0 {
+			return 0
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return time.Duration(r.Int63n(int64(d) + 1))
+	}
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryBudget holds the retries left for all files of one Download call,
+// see DownloadOptions.MaxTotalRetries.
+type retryBudget struct {
+	left int64
+}
+
+type retryBudgetKey struct{}
+
+// withRetryBudget returns a context sharing a budget of n retries between
+// all ranges downloaded with it.
+func withRetryBudget(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, &retryBudget{left: int64(n)})
+}
+
+// takeRetry uses up one retry of the budget of ctx and returns how many
+// are left, or -1 when ctx has no budget. ok is false once the budget is
+// exhausted.
+func takeRetry(ctx context.Context) (left int64, ok bool) {
+	b, _ := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	if b == nil {
+		return -1, true
+	}
+	left = atomic.AddInt64(&b.left, -1)
+	if left < 0 {
+		return 0, false
+	}
+	return left, true
+}
+
+// partBreaker aborts a file once too many of its parts failed, see
+// DownloadOptions.PartFailureThreshold.
+type partBreaker struct {
+	parts     int
+	threshold float64
+	failed    int64
+}
+
+type partBreakerKey struct{}
+
+// withPartBreaker returns a context whose file of parts parts is aborted
+// once more than threshold of them failed.
+func withPartBreaker(ctx context.Context, parts int, threshold float64) context.Context {
+	return context.WithValue(ctx, partBreakerKey{}, &partBreaker{parts: parts, threshold: threshold})
+}
+
+// partFailed counts a part of the file of ctx which failed with err. It
+// returns an error wrapping ErrTooManyPartFailures once too many did.
+func partFailed(ctx context.Context, url string, err error) error {
+	b, _ := ctx.Value(partBreakerKey{}).(*partBreaker)
+	if b == nil {
+		return nil
+	}
+	n := atomic.AddInt64(&b.failed, 1)
+	if float64(n) <= b.threshold*float64(b.parts) {
+		return nil
+	}
+	return fmt.Errorf("%w: %d of %d parts of %s failed, last error: %v", ErrTooManyPartFailures, n, b.parts, url, err)
+}
@@ -0,0 +1,279 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anupam111/concurrent-downloader/internal/download/internal/testserver"
+)
+
+// noJitter makes retries of d immediate.
+func noJitter(d *Downloader) *Downloader {
+	d.jitter = func(time.Duration) time.Duration { return 0 }
+	return d
+}
+
+// unavailableServer announces 100 bytes for every path but answers every
+// GET with 503 and counts them.
+func unavailableServer(gets *int32) *testserver.FileServer {
+	s := newServer()
+	s.AddAll(fixture(100))
+	s.FailFunc = func(r *http.Request) *testserver.Failure {
+		if r.Method == http.MethodHead {
+			return nil
+		}
+		atomic.AddInt32(gets, 1)
+		return &testserver.Failure{Status: http.StatusServiceUnavailable}
+	}
+	return s
+}
+
+func TestMaxTotalRetries(t *testing.T) {
+	var gets int32
+	srv := unavailableServer(&gets)
+	defer srv.Close()
+
+	d := noJitter(NewDownloader(DownloadOptions{Storage: newMemStorage(), MaxRetries: 5, MaxTotalRetries: 3}))
+	urls := []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c", srv.URL + "/d"}
+	results, _ := d.DownloadAll(context.Background(), urls...)
+	// Every file is tried once, the budget adds 3 retries for all of them.
+	if got := atomic.LoadInt32(&gets); got != 4+3 {
+		t.Errorf("got %d GET requests, want 4 plus 3 retries", got)
+	}
+	var exhausted int
+	for _, r := range results {
+		if r.Err == nil {
+			t.Errorf("%s succeeded", r.URL)
+		}
+		if errors.Is(r.Err, ErrRetryBudgetExhausted) {
+			exhausted++
+		}
+	}
+	if exhausted == 0 {
+		t.Error("no file failed with ErrRetryBudgetExhausted")
+	}
+
+	// Every batch gets a fresh budget.
+	atomic.StoreInt32(&gets, 0)
+	d.DownloadAll(context.Background(), urls[0])
+	if got := atomic.LoadInt32(&gets); got != 1+3 {
+		t.Errorf("second batch got %d GET requests, want 1 plus 3 retries", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 500 * time.Millisecond},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{6, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	jitter := newJitter(rand.NewSource(1))
+	for attempt := 0; attempt < 10; attempt++ {
+		max := backoff(attempt)
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			wait := jitter(max)
+			if wait < 0 || wait > max {
+				t.Fatalf("jitter(%v) = %v, want a wait between 0 and %v", max, wait, max)
+			}
+			seen[wait] = true
+		}
+		if len(seen) < 50 {
+			t.Errorf("100 jitters of %v gave only %d different waits", max, len(seen))
+		}
+	}
+	if jitter(0) != 0 || jitter(-time.Second) != 0 {
+		t.Error("jitter of no wait is not 0")
+	}
+
+	// The same source gives the same waits.
+	a, b := newJitter(rand.NewSource(42)), newJitter(rand.NewSource(42))
+	for i := 0; i < 10; i++ {
+		if x, y := a(time.Second), b(time.Second); x != y {
+			t.Fatalf("wait %d = %v and %v with the same source", i, x, y)
+		}
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	d := NewDownloader(DownloadOptions{MaxRetryAfter: 10 * time.Second})
+	d.jitter = func(max time.Duration) time.Duration { return max / 4 }
+	if got := d.retryWait(errors.New("connection reset"), 2); got != 500*time.Millisecond {
+		t.Errorf("retryWait() = %v, want the jittered backoff of 2s", got)
+	}
+	// The Retry-After of the server is used as is, up to MaxRetryAfter.
+	if got := d.retryWait(&statusError{code: http.StatusServiceUnavailable, retryAfter: 3 * time.Second}, 2); got != 3*time.Second {
+		t.Errorf("retryWait() with Retry-After 3s = %v", got)
+	}
+	if got := d.retryWait(&statusError{code: http.StatusTooManyRequests, retryAfter: time.Minute}, 0); got != 10*time.Second {
+		t.Errorf("retryWait() with Retry-After 1m = %v, want MaxRetryAfter", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Unix(40, 0)
+	tests := []struct {
+		v    string
+		want time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{time.Unix(100, 0).UTC().Format(http.TimeFormat), time.Minute},
+		// A date in the past retries right away.
+		{time.Unix(10, 0).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.v, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var gets int32
+	// The first GET is rate limited for a second.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && atomic.AddInt32(&gets, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(fixture(100)))
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage(), MaxRetries: 1})
+	start := time.Now()
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the Retry-After of 1s", elapsed)
+	}
+
+	// MaxRetryAfter shortens the wait.
+	atomic.StoreInt32(&gets, 0)
+	d = NewDownloader(DownloadOptions{Storage: newMemStorage(), MaxRetries: 1, MaxRetryAfter: 10 * time.Millisecond})
+	start = time.Now()
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("retried after %v, want MaxRetryAfter of 10ms", elapsed)
+	}
+	if got := atomic.LoadInt32(&gets); got != 2 {
+		t.Errorf("got %d GET requests, want the rate limited one and its retry", got)
+	}
+}
+
+func TestConnectionResetResumesPart(t *testing.T) {
+	data := fixture(12 << 20)
+	const cut = 4<<20 + 1<<20
+	var dropped int32
+	// The middle part drops its connection after its first 1MB, once.
+	srv := newServer()
+	defer srv.Close()
+	srv.AddAll(data)
+	srv.FailFunc = func(r *http.Request) *testserver.Failure {
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=4194304-") && atomic.CompareAndSwapInt32(&dropped, 0, 1) {
+			return &testserver.Failure{After: 1 << 20}
+		}
+		return nil
+	}
+
+	// The resumed range doesn't need a retry.
+	dir := t.TempDir()
+	d := noJitter(NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 3}))
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "f.bin"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file has %d bytes and %v, want the served file", len(got), err)
+	}
+	ranges := srv.Ranges()
+	sort.Strings(ranges)
+	want := []string{"bytes=0-4194303", "bytes=4194304-8388607", fmt.Sprintf("bytes=%d-8388607", cut), "bytes=8388608-12582911"}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("got the ranges %q, want the rest of the dropped part requested %q", ranges, want)
+	}
+}
+
+func TestPartBreaker(t *testing.T) {
+	ctx := withPartBreaker(context.Background(), 4, 0.5)
+	for i := 1; i <= 3; i++ {
+		err := partFailed(ctx, "f.bin", errors.New("reset"))
+		if wantTrip := i == 3; errors.Is(err, ErrTooManyPartFailures) != wantTrip {
+			t.Errorf("failure %d of 4 parts = %v, want ErrTooManyPartFailures %v", i, err, wantTrip)
+		}
+	}
+	if err := partFailed(context.Background(), "f.bin", errors.New("reset")); err != nil {
+		t.Errorf("partFailed() without a breaker = %v", err)
+	}
+}
+
+func TestPartFailureThreshold(t *testing.T) {
+	data := fixture(12 << 20)
+	var failed int32
+	// Only the first of the 4 parts can be downloaded.
+	srv := newServer()
+	defer srv.Close()
+	srv.AddAll(data)
+	srv.FailFunc = func(r *http.Request) *testserver.Failure {
+		if rg := r.Header.Get("Range"); rg != "" && !strings.HasPrefix(rg, "bytes=0-") {
+			atomic.AddInt32(&failed, 1)
+			return &testserver.Failure{Status: http.StatusServiceUnavailable}
+		}
+		return nil
+	}
+
+	download := func(threshold float64) (int32, error) {
+		atomic.StoreInt32(&failed, 0)
+		d := NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), NumConcParts: 4, MaxRetries: 4, PartFailureThreshold: threshold})
+		d.jitter = func(time.Duration) time.Duration { return 20 * time.Millisecond }
+		_, err := d.Download(srv.URL + "/f.bin")
+		return atomic.LoadInt32(&failed), err
+	}
+	all, err := download(0)
+	if err == nil || errors.Is(err, ErrTooManyPartFailures) {
+		t.Fatalf("Download() without a threshold error = %v, want the 503 of the parts", err)
+	}
+	// 3 parts of 4 fail, more than half of them.
+	aborted, err := download(0.5)
+	if !errors.Is(err, ErrTooManyPartFailures) {
+		t.Fatalf("Download() error = %v, want ErrTooManyPartFailures", err)
+	}
+	if aborted >= all {
+		t.Errorf("aborted download sent %d failing requests, want fewer than the %d without a threshold", aborted, all)
+	}
+}
@@ -0,0 +1,65 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// TotalSize sends a HEAD request for every url, at most MaxLimitConcurrency
+// at once, and returns the sum of their sizes. unknown is the number of
+// urls whose size the server doesn't report, total is then a lower bound.
+// The size of ftp urls is always unknown.
+func (d *Downloader) TotalSize(ctx context.Context, fileUrls ...string) (total int64, unknown int, err error) {
+	g, ctx := errgroup.WithContext(ctx)
+	var sem *semaphore.Weighted
+	if d.downloadOptions.MaxLimitConcurrency > 0 {
+		sem = semaphore.NewWeighted(int64(d.downloadOptions.MaxLimitConcurrency))
+	}
+	var unknownCount int32
+	// acquireErr stops the loop when ctx is done before every url got a
+	// slot, the sum would miss the remaining urls.
+	var acquireErr error
+	for _, fileUrl := range fileUrls {
+		fileUrl := fileUrl
+		if sem != nil {
+			if acquireErr = sem.Acquire(ctx, 1); acquireErr != nil {
+				break
+			}
+		}
+		g.Go(func() error {
+			if sem != nil {
+				defer sem.Release(1)
+			}
+			scheme, err := urlScheme(fileUrl)
+			if err != nil {
+				return err
+			}
+			if scheme == "ftp" {
+				atomic.AddInt32(&unknownCount, 1)
+				return nil
+			}
+			size, _, err := d.checkFileSizeWithHeaderContentLength(ctx, fileRequest{url: fileUrl}, time.Time{})
+			if err != nil {
+				return fmt.Errorf("error while checking the size of %s: %w", fileUrl, err)
+			}
+			if size < 0 {
+				atomic.AddInt32(&unknownCount, 1)
+				return nil
+			}
+			atomic.AddInt64(&total, size)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return 0, 0, err
+	}
+	if acquireErr != nil {
+		return 0, 0, acquireErr
+	}
+	return total, int(unknownCount), nil
+}
@@ -0,0 +1,251 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anupam111/concurrent-downloader/internal/download/internal/testserver"
+)
+
+// noHeadServer rejects HEAD requests with 405 and serves data to GETs for
+// every path.
+func noHeadServer(data []byte) *testserver.FileServer {
+	s := newServer()
+	s.AddAll(data)
+	s.FailFunc = func(r *http.Request) *testserver.Failure {
+		if r.Method == http.MethodHead {
+			return &testserver.Failure{Status: http.StatusMethodNotAllowed}
+		}
+		return nil
+	}
+	return s
+}
+
+func TestProbeWithoutHead(t *testing.T) {
+	data := fixture(12 << 20)
+	srv := noHeadServer(data)
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 3})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The size from the Content-Range of the probe plans the parts.
+	if results[0].Concurrency != 3 || !bytes.Equal(storage.file("f.bin"), data) {
+		t.Errorf("downloaded %d bytes in %d parts, want the file in 3 parts", len(storage.file("f.bin")), results[0].Concurrency)
+	}
+	if got := srv.Ranges(); len(got) != 4 || got[0] != "bytes=0-0" {
+		t.Errorf("got the ranges %q, want a bytes=0-0 probe and 3 parts", got)
+	}
+
+	size, _, err := d.checkFileSizeWithHeaderContentLength(context.Background(), fileRequest{url: srv.URL + "/f.bin"}, time.Time{})
+	if err != nil || size != int64(len(data)) {
+		t.Errorf("checkFileSizeWithHeaderContentLength() = %d, %v, want %d", size, err, len(data))
+	}
+}
+
+func TestProbeWithoutHeadEmpty(t *testing.T) {
+	srv := noHeadServer(nil)
+	defer srv.Close()
+
+	// An empty file can't satisfy the probe range.
+	d := NewDownloader(DownloadOptions{})
+	size, _, err := d.checkFileSizeWithHeaderContentLength(context.Background(), fileRequest{url: srv.URL + "/f.bin"}, time.Time{})
+	if err != nil || size != 0 {
+		t.Errorf("checkFileSizeWithHeaderContentLength() = %d, %v, want 0", size, err)
+	}
+}
+
+func TestTotalSize(t *testing.T) {
+	srv := serve(map[string][]byte{"/a": fixture(10), "/b": fixture(2000), "/c": fixture(5)})
+	defer srv.Close()
+	// No Content-Length, the response is chunked.
+	unknown := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+	}))
+	defer unknown.Close()
+
+	d := NewDownloader(DownloadOptions{MaxLimitConcurrency: 2})
+	total, n, err := d.TotalSize(context.Background(), srv.URL+"/a", srv.URL+"/b", srv.URL+"/c", unknown.URL+"/x", "ftp://example.com/f.bin")
+	if err != nil || total != 2015 || n != 2 {
+		t.Errorf("TotalSize() = %d, %d, %v, want 2015 bytes and 2 unknown sizes", total, n, err)
+	}
+	if total, n, err := d.TotalSize(context.Background()); err != nil || total != 0 || n != 0 {
+		t.Errorf("TotalSize() of no urls = %d, %d, %v", total, n, err)
+	}
+	if _, _, err := d.TotalSize(context.Background(), srv.URL+"/a", srv.URL+"/missing"); err == nil || !strings.Contains(err.Error(), "/missing") {
+		t.Errorf("TotalSize() with a missing url error = %v, want it named", err)
+	}
+
+	// Urls still waiting for a slot when ctx is done fail the sum.
+	block := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+		w.Header().Set("Content-Length", "10")
+	}))
+	defer slow.Close()
+	defer close(block)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	d = NewDownloader(DownloadOptions{MaxLimitConcurrency: 1})
+	if total, _, err := d.TotalSize(ctx, slow.URL+"/a", srv.URL+"/b"); err == nil {
+		t.Errorf("TotalSize() after ctx was done = %d, want an error", total)
+	}
+}
+
+func TestProbeChunkedRangeable(t *testing.T) {
+	data := fixture(12 << 20)
+	var mu sync.Mutex
+	var ranges []string
+	// HEAD advertises ranges without a size, GETs honor them.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		if r.Header.Get("Range") == "" {
+			w.(http.Flusher).Flush()
+			w.Write(data)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 3})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Concurrency != 3 || !bytes.Equal(storage.file("f.bin"), data) {
+		t.Errorf("downloaded %d bytes in %d parts, want the file in 3 parts", len(storage.file("f.bin")), results[0].Concurrency)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 4 || ranges[0] != "bytes=0-0" {
+		t.Errorf("got the ranges %q, want a bytes=0-0 probe and 3 parts", ranges)
+	}
+}
+
+func TestProbeWorkers(t *testing.T) {
+	var mu sync.Mutex
+	var probing, peak int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			mu.Lock()
+			probing++
+			if probing > peak {
+				peak = probing
+			}
+			mu.Unlock()
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+			probing--
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Length", "2")
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	var urls []string
+	for i := 0; i < 40; i++ {
+		urls = append(urls, fmt.Sprintf("%s/f%d.bin", srv.URL, i))
+	}
+	tests := []struct {
+		opts     DownloadOptions
+		wantPeak int
+	}{
+		{DownloadOptions{ProbeWorkers: 20}, 20},
+		{DownloadOptions{MaxLimitConcurrency: 10}, 10},
+		{DownloadOptions{}, 8},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		peak = 0
+		mu.Unlock()
+		tt.opts.Storage = newMemStorage()
+		start := time.Now()
+		if _, err := NewDownloader(tt.opts).DownloadAll(context.Background(), urls...); err != nil {
+			t.Fatal(err)
+		}
+		// 40 serial probes take 4s.
+		elapsed := time.Since(start)
+		if want := time.Duration(40/tt.wantPeak+2) * 100 * time.Millisecond; elapsed > want {
+			t.Errorf("DownloadAll() with %+v took %v, want the probes in parallel within %v", tt.opts, elapsed, want)
+		}
+		mu.Lock()
+		if peak != tt.wantPeak {
+			t.Errorf("%d probes ran at once with %+v, want %d", peak, tt.opts, tt.wantPeak)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestExpectedSizes(t *testing.T) {
+	var mu sync.Mutex
+	gets := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			gets[r.URL.Path]++
+			mu.Unlock()
+		}
+		if strings.HasPrefix(r.URL.Path, "/chunked") {
+			// No size before the download.
+			w.(http.Flusher).Flush()
+			w.Write(fixture(100))
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(fixture(100)))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, ExpectedSizes: map[string]int64{
+		srv.URL + "/ok.bin":         100,
+		srv.URL + "/bad.bin":        99,
+		srv.URL + "/chunked.bin":    99,
+		srv.URL + "/chunked-ok.bin": 100,
+	}})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/ok.bin", srv.URL+"/bad.bin", srv.URL+"/chunked.bin", srv.URL+"/other.bin", srv.URL+"/chunked-ok.bin")
+	if !errors.Is(err, ErrUnexpectedSize) {
+		t.Errorf("DownloadAll() error = %v, want ErrUnexpectedSize", err)
+	}
+	for _, i := range []int{0, 3, 4} {
+		if results[i].Err != nil {
+			t.Errorf("error of %s = %v, want it downloaded", results[i].URL, results[i].Err)
+		}
+	}
+	for _, i := range []int{1, 2} {
+		if !errors.Is(results[i].Err, ErrUnexpectedSize) {
+			t.Errorf("error of %s = %v, want ErrUnexpectedSize", results[i].URL, results[i].Err)
+		}
+	}
+	mu.Lock()
+	// The HEAD response of bad.bin already rejects it, chunked.bin only
+	// shows its size once downloaded.
+	if gets["/bad.bin"] != 0 || gets["/chunked.bin"] != 1 {
+		t.Errorf("got %d GETs of bad.bin and %d of chunked.bin, want 0 and 1", gets["/bad.bin"], gets["/chunked.bin"])
+	}
+	mu.Unlock()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("download dir has %d files, want only the 3 downloaded", len(entries))
+	}
+}
@@ -0,0 +1,60 @@
+package download
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// speedSample is the shortest interval the speed is measured over, so
+	// that small writes in quick succession do not make it jump around.
+	speedSample = 200 * time.Millisecond
+	// speedHalfLife is how long it takes for a measured speed to account
+	// for only half of the smoothed speed.
+	speedHalfLife = 2 * time.Second
+)
+
+// speedMeter computes the exponential moving average of the download speed
+// of a file. It is shared by all parts of the file.
+type speedMeter struct {
+	mu      sync.Mutex
+	last    time.Time
+	pending int64
+	rate    float64
+}
+
+// add records n bytes written at now and returns the smoothed speed in
+// bytes per second, zero until the first sample was taken.
+func (m *speedMeter) add(n int64, now time.Time) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last.IsZero() {
+		m.last = now
+		return 0
+	}
+	m.pending += n
+	elapsed := now.Sub(m.last)
+	if elapsed < speedSample {
+		return m.rate
+	}
+	measured := float64(m.pending) / elapsed.Seconds()
+	if m.rate == 0 {
+		m.rate = measured
+	} else {
+		weight := 1 - math.Exp2(-float64(elapsed)/float64(speedHalfLife))
+		m.rate += weight * (measured - m.rate)
+	}
+	m.last = now
+	m.pending = 0
+	return m.rate
+}
+
+// eta returns the time the remaining bytes take at rate, zero when either
+// is unknown.
+func eta(remaining int64, rate float64) time.Duration {
+	if remaining <= 0 || rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Second))
+}
@@ -0,0 +1,100 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSpeedMeter(t *testing.T) {
+	var m speedMeter
+	now := time.Unix(0, 0)
+	if r := m.add(1000, now); r != 0 {
+		t.Errorf("speed before the first sample = %v, want 0", r)
+	}
+
+	// 1000 bytes every 100ms.
+	var r float64
+	for i := 0; i < 100; i++ {
+		now = now.Add(100 * time.Millisecond)
+		r = m.add(1000, now)
+	}
+	if r < 9500 || r > 10500 {
+		t.Errorf("speed at 10000 bytes/s = %v", r)
+	}
+	// Writes closer together than a sample don't move the speed.
+	if got := m.add(1<<20, now.Add(time.Millisecond)); got != r {
+		t.Errorf("speed within a sample moved from %v to %v", r, got)
+	}
+	now = now.Add(100 * time.Millisecond)
+	m.add(1000, now)
+
+	// The speed doubles, the average follows within a few half lives.
+	for i := 0; i < 200; i++ {
+		now = now.Add(100 * time.Millisecond)
+		r = m.add(2000, now)
+	}
+	if r < 19000 || r > 21000 {
+		t.Errorf("speed at 20000 bytes/s = %v", r)
+	}
+}
+
+func TestETA(t *testing.T) {
+	tests := []struct {
+		remaining int64
+		rate      float64
+		want      time.Duration
+	}{
+		{20000, 20000, time.Second},
+		{5000, 20000, 250 * time.Millisecond},
+		{0, 20000, 0},
+		{-1, 20000, 0},
+		{20000, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := eta(tt.remaining, tt.rate); got != tt.want {
+			t.Errorf("eta(%d, %v) = %v, want %v", tt.remaining, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestProgressSpeed(t *testing.T) {
+	data := fixture(1 << 20)
+	// A throttled server, the file takes more than a second.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, &throttledReader{ReadSeeker: bytes.NewReader(data), rate: 2 << 20})
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage()})
+	var measured []Event
+	start := time.Now()
+	for ev := range d.DownloadStream(context.Background(), srv.URL+"/f.bin") {
+		if ev.Type == Progress && ev.Speed > 0 {
+			measured = append(measured, ev)
+		}
+	}
+	rate := float64(len(data)) / time.Since(start).Seconds()
+	if len(measured) == 0 {
+		t.Fatal("no Progress event with a Speed")
+	}
+	var withETA int
+	for _, ev := range measured {
+		if ev.Speed < rate/2 || ev.Speed > rate*2 {
+			t.Errorf("Speed = %v, want about the %v bytes/s of the download", ev.Speed, rate)
+		}
+		if ev.ETA > 0 {
+			withETA++
+		}
+		left := time.Duration(float64(ev.Total-ev.Downloaded) / rate * float64(time.Second))
+		if ev.ETA > 2*left+time.Second/10 {
+			t.Errorf("ETA = %v with %d of %d bytes left, want about %v", ev.ETA, ev.Total-ev.Downloaded, ev.Total, left)
+		}
+	}
+	if withETA == 0 {
+		t.Error("no Progress event with an ETA")
+	}
+}
@@ -0,0 +1,52 @@
+package download
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// stallWatcher cancels a request when the gap between two successful reads
+// of its body exceeds the timeout.
+type stallWatcher struct {
+	timeout time.Duration
+	timer   *time.Timer
+	stalled int32
+}
+
+func newStallWatcher(timeout time.Duration, cancel func()) *stallWatcher {
+	s := &stallWatcher{timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&s.stalled, 1)
+		cancel()
+	})
+	return s
+}
+
+// reader wraps r so that every read returning data resets the timer.
+func (s *stallWatcher) reader(r io.Reader) io.Reader {
+	return &stallReader{r: r, s: s}
+}
+
+// fired reports whether the timer cancelled the request. It is safe to call
+// on a nil watcher.
+func (s *stallWatcher) fired() bool {
+	return s != nil && atomic.LoadInt32(&s.stalled) == 1
+}
+
+func (s *stallWatcher) stop() {
+	s.timer.Stop()
+}
+
+type stallReader struct {
+	r io.Reader
+	s *stallWatcher
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 && !r.s.fired() {
+		r.s.timer.Reset(r.s.timeout)
+	}
+	return n, err
+}
@@ -0,0 +1,85 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anupam111/concurrent-downloader/internal/download/internal/testserver"
+)
+
+// stallServer serves data for every path. The first GET sends the first
+// sent bytes, then pauses for pause before closing the connection.
+func stallServer(data []byte, sent int, pause time.Duration) *testserver.FileServer {
+	s := newServer()
+	s.AddAll(data)
+	var gets int32
+	s.FailFunc = func(r *http.Request) *testserver.Failure {
+		if r.Method == http.MethodGet && atomic.AddInt32(&gets, 1) == 1 {
+			return &testserver.Failure{After: int64(sent), Stall: pause}
+		}
+		return nil
+	}
+	return s
+}
+
+func TestStallTimeoutResumes(t *testing.T) {
+	data := fixture(5000)
+	srv := stallServer(data, 1000, 2*time.Second)
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, StallTimeout: 100 * time.Millisecond})
+	start := time.Now()
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("DownloadAll() took %v, the stall was not detected", elapsed)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("downloaded file differs")
+	}
+	if got := srv.Ranges(); len(got) != 2 || got[1] != "bytes=1000-4999" {
+		t.Errorf("got the ranges %q, want the remaining bytes requested from offset 1000", got)
+	}
+}
+
+func TestStallTimeout(t *testing.T) {
+	data := fixture(5000)
+	srv := stallServer(data, 0, 2*time.Second)
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{Storage: newMemStorage(), StallTimeout: 100 * time.Millisecond})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); !errors.Is(err, ErrStalled) {
+		t.Fatalf("DownloadAll() error = %v, want ErrStalled", err)
+	}
+
+	// A body trickling in for longer than the timeout doesn't stall as long
+	// as the gaps between the reads are shorter.
+	trickle := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5000")
+		if r.Method == http.MethodHead {
+			return
+		}
+		for off := 0; off < len(data); off += 500 {
+			w.Write(data[off : off+500])
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer trickle.Close()
+	storage := newMemStorage()
+	d = NewDownloader(DownloadOptions{Storage: storage, StallTimeout: 100 * time.Millisecond})
+	if _, err := d.DownloadAll(context.Background(), trickle.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("downloaded file differs")
+	}
+}
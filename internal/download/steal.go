@@ -0,0 +1,113 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// errStolen stops the download of a part once its remaining bytes were
+// taken over by another worker.
+var errStolen = errors.New("rest of the range was taken over")
+
+// stealQueue holds the parts of a file downloaded with
+// DownloadOptions.WorkStealing. A worker done with its part splits the part
+// with the most bytes left and downloads the second half itself.
+type stealQueue struct {
+	mu sync.Mutex
+	// minSize is the smallest half a part is split into.
+	minSize int64
+	parts   []*stealPart
+}
+
+// stealPart writes a part through its offsetWriter until the end of the
+// part, which is lowered when its tail is stolen.
+type stealPart struct {
+	q    *stealQueue
+	part *offsetWriter
+	end  int64
+}
+
+// add registers part, which ends at the inclusive offset end.
+func (q *stealQueue) add(part *offsetWriter, end int64) *stealPart {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p := &stealPart{q: q, part: part, end: end}
+	q.parts = append(q.parts, p)
+	return p
+}
+
+// steal splits the part with the most bytes left and returns the second
+// half along with a writer for it. ok is false when no part has enough
+// bytes left to be worth splitting.
+func (q *stealQueue) steal() (r byteRange, w *stealPart, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var victim *stealPart
+	var most int64
+	for _, p := range q.parts {
+		if left := p.end - p.part.off + 1; left > most {
+			victim, most = p, left
+		}
+	}
+	if victim == nil || most < 2*q.minSize {
+		return byteRange{}, nil, false
+	}
+	r = byteRange{start: victim.part.off + most/2, end: victim.end}
+	victim.end = r.start - 1
+	w = &stealPart{q: q, part: &offsetWriter{w: victim.part.w, start: r.start, off: r.start}, end: r.end}
+	q.parts = append(q.parts, w)
+	return r, w, true
+}
+
+// written returns the bytes written by all parts.
+func (q *stealQueue) written() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var n int64
+	for _, p := range q.parts {
+		n += p.part.written()
+	}
+	return n
+}
+
+func (p *stealPart) Write(b []byte) (int, error) {
+	p.q.mu.Lock()
+	off := p.part.off
+	stolen := off+int64(len(b)) > p.end+1
+	if stolen {
+		b = b[:p.end+1-off]
+	}
+	// Reserve the bytes so a concurrent steal splits behind them.
+	p.part.off += int64(len(b))
+	p.q.mu.Unlock()
+
+	n, err := p.part.w.WriteAt(b, off)
+	if err != nil {
+		p.q.mu.Lock()
+		p.part.off = off + int64(n)
+		p.q.mu.Unlock()
+		return n, err
+	}
+	if stolen {
+		return n, errStolen
+	}
+	return n, nil
+}
+
+// stealWork takes over the tail of the part with the most bytes left until
+// no part is worth splitting anymore. wrap is applied to the writer of every
+// stolen range, size is the length of the file.
+func (d *Downloader) stealWork(ctx context.Context, req fileRequest, size int64, q *stealQueue, wrap func(io.Writer) io.Writer) error {
+	for {
+		r, w, ok := q.steal()
+		if !ok {
+			return nil
+		}
+		d.printf("taking over range %d-%d of %s\n", r.start, r.end, req.url)
+		if err := d.downloadFileForRange(ctx, req, size, r.start, r.end, wrap(w), true); err != nil && !errors.Is(err, errStolen) {
+			return err
+		}
+	}
+}
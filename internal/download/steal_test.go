@@ -0,0 +1,97 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowReader reads at most 32KB every 10ms.
+type slowReader struct{ r io.Reader }
+
+func (s slowReader) Read(p []byte) (int, error) {
+	if len(p) > 32<<10 {
+		p = p[:32<<10]
+	}
+	time.Sleep(10 * time.Millisecond)
+	return s.r.Read(p)
+}
+
+func TestWorkStealing(t *testing.T) {
+	data := fixture(12 << 20)
+	half := len(data) / 2
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		// The first part trickles, the second one is done at once.
+		if r.Header.Get("Range") == "bytes=0-"+strconv.Itoa(half-1) {
+			w.Header().Set("Content-Range", "bytes 0-"+strconv.Itoa(half-1)+"/"+strconv.Itoa(len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(half))
+			w.WriteHeader(http.StatusPartialContent)
+			io.Copy(w, slowReader{bytes.NewReader(data[:half])})
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, NumConcParts: 2, WorkStealing: true})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Size != int64(len(data)) {
+		t.Errorf("Size = %d, want %d", results[0].Size, len(data))
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "f.bin")); !bytes.Equal(b, data) {
+		t.Error("downloaded file differs")
+	}
+
+	// The worker done with the second part took over the tail of the first.
+	mu.Lock()
+	defer mu.Unlock()
+	var stolen int
+	for _, r := range ranges {
+		var start int
+		if _, err := fmt.Sscanf(r, "bytes=%d-", &start); err == nil && start > 0 && start < half {
+			stolen++
+		}
+	}
+	if stolen == 0 {
+		t.Errorf("got the ranges %q, want the tail of the slow part requested again", ranges)
+	}
+}
+
+func TestStealQueueMinSize(t *testing.T) {
+	f := &offsetWriter{}
+	q := &stealQueue{minSize: 100}
+	q.add(f, 399)
+	r, _, ok := q.steal()
+	if !ok || r != (byteRange{start: 200, end: 399}) {
+		t.Fatalf("steal() = %+v, %v, want the second half 200-399", r, ok)
+	}
+	// Halves of 100 bytes are still allowed, smaller ones are not.
+	for _, want := range []byteRange{{start: 100, end: 199}, {start: 300, end: 399}} {
+		if r, _, ok := q.steal(); !ok || r != want {
+			t.Fatalf("steal() = %+v, %v, want %+v", r, ok, want)
+		}
+	}
+	if r, _, ok := q.steal(); ok {
+		t.Errorf("steal() = %+v, want no part worth splitting", r)
+	}
+}
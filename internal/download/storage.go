@@ -0,0 +1,263 @@
+package download
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage is where downloaded files are written to. The names passed to it
+// are already sanitized base names, or "/" separated relative paths with
+// DownloadOptions.PreservePath.
+type Storage interface {
+	// Create creates the named file. The returned writer is closed once all
+	// the bytes are written; when it also implements io.WriterAt the parts of
+	// a file are written into it concurrently at their offsets.
+	Create(name string) (io.WriteCloser, error)
+	// Exists reports whether the named file already exists.
+	Exists(name string) bool
+	// Rename renames oldName to newName, replacing newName if it exists.
+	Rename(oldName, newName string) error
+	// Remove removes the named file.
+	Remove(name string) error
+}
+
+// LocalStorage stores files in a directory of the local filesystem.
+// It is the default Storage, rooted at DownloadOptions.DownloadDir.
+type LocalStorage struct {
+	Dir string
+	// FileMode is the permission files are created with, before the umask.
+	// Defaults to 0644.
+	FileMode os.FileMode
+	// DirMode is the permission Dir is created with when it doesn't exist,
+	// before the umask. Defaults to 0755.
+	DirMode os.FileMode
+}
+
+func (s LocalStorage) Create(name string) (io.WriteCloser, error) {
+	path, err := safePath(s.Dir, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mkdir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.fileMode())
+}
+
+// mkdir creates dir, Dir or one of its subdirectories, with its parents
+// unless it already exists.
+func (s LocalStorage) mkdir(dir string) error {
+	if dir == "" || dir == "." {
+		return nil
+	}
+	mode := s.DirMode
+	if mode == 0 {
+		mode = 0755
+	}
+	return os.MkdirAll(dir, mode)
+}
+
+func (s LocalStorage) fileMode() os.FileMode {
+	if s.FileMode == 0 {
+		return 0644
+	}
+	return s.FileMode
+}
+
+func (s LocalStorage) Exists(name string) bool {
+	path, err := safePath(s.Dir, name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+func (s LocalStorage) Rename(oldName, newName string) error {
+	oldPath, err := safePath(s.Dir, oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := safePath(s.Dir, newName)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (s LocalStorage) Remove(name string) error {
+	path, err := safePath(s.Dir, name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// outputFile is a file being downloaded. It is written under a temporary
+// name and only renamed to its final name by commit, so a failed download
+// never leaves a file under the final name.
+type outputFile struct {
+	io.WriteCloser
+	storage   Storage
+	name      string
+	tempName  string
+	path      string
+	closed    bool
+	committed bool
+	// keep leaves the temporary file in place when the download fails, so
+	// that it can be resumed.
+	keep bool
+}
+
+// createOutputFile creates the file for the given name in the storage of req.
+// The name is passed through safeName so it can never point outside of
+// DownloadDir. An existing file is an error unless replace is set.
+// The path of the returned file is its final local path when storage
+// provides one and the sanitized name otherwise.
+func (d *Downloader) createOutputFile(ctx context.Context, req fileRequest, fileName string, replace bool) (*outputFile, error) {
+	storage := d.storage(req)
+	name, err := d.cleanName(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !replace && storage.Exists(name) {
+		return nil, fmt.Errorf("%w : %s", ErrFileExists, name)
+	}
+
+	tempName, err := d.inProgressName(name)
+	if err == nil && tempName == "" {
+		tempName, err = tempFileName(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w io.WriteCloser
+	err = d.retryOpenFiles(ctx, func() (err error) {
+		w, err = storage.Create(tempName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while creating file %s: %w", tempName, err)
+	}
+
+	path := name
+	if named, ok := w.(interface{ Name() string }); ok {
+		path = filepath.Join(filepath.Dir(named.Name()), localName(filepath.Base(name)))
+	}
+	return &outputFile{WriteCloser: w, storage: storage, name: name, tempName: tempName, path: path}, nil
+}
+
+// inProgressName returns name with InProgressSuffix, or "" when it is
+// unset.
+func (d *Downloader) inProgressName(name string) (string, error) {
+	suffix := d.downloadOptions.InProgressSuffix
+	if suffix == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(suffix, `/\`) {
+		return "", fmt.Errorf("%w: in progress suffix %q contains a path separator", ErrInvalidFileName, suffix)
+	}
+	return name + suffix, nil
+}
+
+// tempFileName returns a hidden, unique name to download name into.
+func tempFileName(name string) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error while generating temp file name: %w", err)
+	}
+	return hiddenName(name, "."+hex.EncodeToString(b)+".tmp"), nil
+}
+
+// local reports whether the file is on the local filesystem.
+func (o *outputFile) local() bool {
+	_, ok := o.WriteCloser.(*os.File)
+	return ok
+}
+
+// commit closes the file and renames it to its final name.
+func (o *outputFile) commit() error {
+	o.closed = true
+	if err := o.Close(); err != nil {
+		return fmt.Errorf("error while closing output file %s: %w", o.path, err)
+	}
+	if err := o.storage.Rename(o.tempName, o.name); err != nil {
+		return fmt.Errorf("error while renaming output file to %s: %w", o.path, err)
+	}
+	o.committed = true
+	return nil
+}
+
+// abort closes and removes the temporary file unless it was committed or
+// is kept for resuming.
+func (o *outputFile) abort() {
+	if o.committed {
+		return
+	}
+	if !o.closed {
+		o.closed = true
+		o.Close()
+	}
+	if !o.keep {
+		o.storage.Remove(o.tempName)
+	}
+}
+
+// storage returns the Storage files of req are downloaded to, the one of
+// req, the configured Storage or a LocalStorage for DownloadDir.
+func (d *Downloader) storage(req fileRequest) Storage {
+	if req.storage != nil {
+		return req.storage
+	}
+	if d.downloadOptions.Storage != nil {
+		return d.downloadOptions.Storage
+	}
+	return LocalStorage{Dir: d.downloadOptions.DownloadDir, FileMode: d.downloadOptions.FileMode, DirMode: d.downloadOptions.DirMode}
+}
+
+// tempDir returns the directory part files are staged in.
+func (d *Downloader) tempDir() string {
+	if d.downloadOptions.TempDir != "" {
+		return d.downloadOptions.TempDir
+	}
+	return d.downloadOptions.DownloadDir
+}
+
+// localPath returns the local path fileName of req is downloaded to, it
+// reports false when its Storage is not a LocalStorage.
+func (d *Downloader) localPath(req fileRequest, fileName string) (string, bool) {
+	local, ok := d.storage(req).(LocalStorage)
+	if !ok {
+		return "", false
+	}
+	name, err := d.cleanName(fileName)
+	if err != nil {
+		return "", false
+	}
+	path, err := safePath(local.Dir, name)
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// localCopy returns the file info of an existing local copy of fileName or
+// nil when there is none.
+func (d *Downloader) localCopy(req fileRequest, fileName string) os.FileInfo {
+	path, ok := d.localPath(req, fileName)
+	if !ok {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+	return fi
+}
@@ -0,0 +1,234 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errStorage fails every Create with err.
+type errStorage struct {
+	LocalStorage
+	err error
+}
+
+func (s errStorage) Create(name string) (io.WriteCloser, error) {
+	return nil, s.err
+}
+
+func TestCreateOutputFileWrapsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"permission", &fs.PathError{Op: "open", Path: "x", Err: fs.ErrPermission}},
+		{"invalid name", fmt.Errorf("%w: file name %q escapes download directory", ErrInvalidFileName, "x")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDownloader(DownloadOptions{Storage: errStorage{LocalStorage{Dir: t.TempDir()}, tt.err}})
+			_, err := d.createOutputFile(context.Background(), fileRequest{}, "file.bin", false)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("createOutputFile() error = %v, want it to wrap %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestOpenResumableWrapsErrors(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir})
+	partialName, _ := resumeNames("file.bin")
+	// A directory in place of the partial file makes the open fail.
+	if err := (LocalStorage{Dir: dir}).mkdir(dir + "/" + partialName); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := d.openResumable(fileRequest{url: "http://example.com/file.bin"}, "file.bin", nil, false, 10, nil)
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatalf("openResumable() error = %v, want a *fs.PathError", err)
+	}
+}
+
+func TestMemStorageCombine(t *testing.T) {
+	small, large := fixture(1000), fixture(12<<20+3)
+	srv := serve(map[string][]byte{"/small.bin": small, "/large.bin": large})
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: t.TempDir(), NumConcParts: 4})
+	results, err := d.DownloadAll(context.Background(), srv.URL+"/small.bin", srv.URL+"/large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The parts are staged in TempDir and combined into the storage, only
+	// the final files are left in it.
+	if results[1].Concurrency != 4 || !bytes.Equal(storage.file("large.bin"), large) {
+		t.Errorf("large.bin was combined from %d parts into %d bytes, want 4 parts and the served file", results[1].Concurrency, len(storage.file("large.bin")))
+	}
+	if !bytes.Equal(storage.file("small.bin"), small) {
+		t.Error("small.bin differs")
+	}
+	if results[0].Path != "small.bin" || len(storage.files) != 2 {
+		t.Errorf("storage has %d files with the paths %q, %q, want only the downloaded files", len(storage.files), results[0].Path, results[1].Path)
+	}
+
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/small.bin"); !errors.Is(err, ErrFileExists) {
+		t.Errorf("DownloadAll() of an existing file error = %v, want ErrFileExists", err)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	s := LocalStorage{Dir: filepath.Join(dir, "sub")}
+	w, err := s.Create("a/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("abc"))
+	w.Close()
+	if !s.Exists("a/f.bin") || s.Exists("a/g.bin") {
+		t.Error("Exists() doesn't report the created file only")
+	}
+	if err := s.Rename("a/f.bin", "g.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "sub", "g.bin")); err != nil || string(b) != "abc" {
+		t.Errorf("renamed file has %q, %v, want abc", b, err)
+	}
+	if err := s.Remove("g.bin"); err != nil || s.Exists("g.bin") {
+		t.Errorf("Remove() = %v, file still exists: %v", err, s.Exists("g.bin"))
+	}
+	w, err = s.Create("../../escape")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Error("Create() wrote outside of Dir")
+	}
+}
+
+func TestPartsStagedInTempDir(t *testing.T) {
+	data := fixture(12 << 20)
+	tempDir := t.TempDir()
+	var mu sync.Mutex
+	var staged []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			// All the parts are created before their requests are sent.
+			mu.Lock()
+			staged, _ = filepath.Glob(filepath.Join(tempDir, "f.bin.*.part"))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	storage := newMemStorage()
+	d := NewDownloader(DownloadOptions{Storage: storage, TempDir: tempDir, NumConcParts: 3})
+	if _, err := d.DownloadAll(context.Background(), srv.URL+"/f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 3 {
+		t.Errorf("found the parts %q in the temp dir, want 3", staged)
+	}
+	if !bytes.Equal(storage.file("f.bin"), data) {
+		t.Error("downloaded file differs")
+	}
+	if left, _ := os.ReadDir(tempDir); len(left) != 0 {
+		t.Errorf("%d parts were left in the temp dir", len(left))
+	}
+}
+
+func TestTempDir(t *testing.T) {
+	tests := []struct {
+		opts DownloadOptions
+		want string
+	}{
+		{DownloadOptions{TempDir: "/tmp/parts", DownloadDir: "/data"}, "/tmp/parts"},
+		{DownloadOptions{DownloadDir: "/data"}, "/data"},
+		{DownloadOptions{}, ""},
+	}
+	for _, tt := range tests {
+		if got := NewDownloader(tt.opts).tempDir(); got != tt.want {
+			t.Errorf("tempDir() with %+v = %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestInProgressSuffix(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/cut.bin" {
+			return
+		}
+		<-release
+		w.Write([]byte("world"))
+	}))
+	defer srv.Close()
+
+	for _, resume := range []bool{false, true} {
+		dir := t.TempDir()
+		d := NewDownloader(DownloadOptions{DownloadDir: dir, InProgressSuffix: ".download", Resume: resume})
+		done := make(chan error, 1)
+		go func() {
+			_, err := d.Download(srv.URL + "/f.bin")
+			done <- err
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if b, _ := os.ReadFile(filepath.Join(dir, "f.bin.download")); bytes.HasPrefix(b, []byte("hello")) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("f.bin.download with Resume %v doesn't hold the first bytes", resume)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "f.bin")); !os.IsNotExist(err) {
+			t.Errorf("f.bin with Resume %v exists before it is complete: %v", resume, err)
+		}
+		release <- struct{}{}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := os.ReadFile(filepath.Join(dir, "f.bin")); string(b) != "helloworld" {
+			t.Errorf("f.bin with Resume %v = %q, want helloworld", resume, b)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "f.bin.download")); !os.IsNotExist(err) {
+			t.Errorf("f.bin.download with Resume %v is left after the rename: %v", resume, err)
+		}
+	}
+
+	// A failed download doesn't leave the suffixed file.
+	dir := t.TempDir()
+	d := NewDownloader(DownloadOptions{DownloadDir: dir, InProgressSuffix: ".download"})
+	if _, err := d.Download(srv.URL + "/cut.bin"); err == nil {
+		t.Error("Download() of a cut off body succeeded")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the failed download left %d files behind", len(entries))
+	}
+
+	d = NewDownloader(DownloadOptions{DownloadDir: t.TempDir(), InProgressSuffix: "/x"})
+	if _, err := d.Download(srv.URL + "/f.bin"); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("Download() with a suffix containing a separator error = %v, want ErrInvalidFileName", err)
+	}
+}
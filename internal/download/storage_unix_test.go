@@ -0,0 +1,56 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileMode(t *testing.T) {
+	srv := serve(map[string][]byte{"/f.bin": fixture(100)})
+	defer srv.Close()
+	old := syscall.Umask(0022)
+	defer syscall.Umask(old)
+
+	tests := []struct {
+		name     string
+		opts     DownloadOptions
+		wantFile os.FileMode
+		wantDir  os.FileMode
+	}{
+		{"default", DownloadOptions{}, 0644, 0755},
+		{"custom", DownloadOptions{FileMode: 0600, DirMode: 0750}, 0600, 0750},
+		{"resume", DownloadOptions{FileMode: 0600, DirMode: 0700, Resume: true}, 0600, 0700},
+		// The umask still applies.
+		{"umask", DownloadOptions{FileMode: 0666, DirMode: 0777}, 0644, 0755},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "sub", "dl")
+			tt.opts.DownloadDir = dir
+			results, err := NewDownloader(tt.opts).DownloadAll(context.Background(), srv.URL+"/f.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(results[0].Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fi.Mode().Perm(); got != tt.wantFile {
+				t.Errorf("file mode = %v, want %v", got, tt.wantFile)
+			}
+			di, err := os.Stat(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := di.Mode().Perm(); got != tt.wantDir {
+				t.Errorf("dir mode = %v, want %v", got, tt.wantDir)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Open returns a stream of the remote file at url. Unlike DownloadTo nothing
+// is staged: the stream requests ranges of MinPartSize bytes one after the
+// other as it is read, so a file of any size is processed in constant
+// memory. A range failing midway is requested again from the first missing
+// byte, up to MaxRetries times in a row. Servers ignoring ranges are
+// streamed in one response. The caller must Close the stream.
+func (d *Downloader) Open(url string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	size, _, err := d.checkFileSizeWithHeaderContentLength(ctx, fileRequest{url: url}, time.Time{})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error while checking the size of the file: %w", err)
+	}
+	return &rangeStream{d: d, ctx: ctx, cancel: cancel, url: url, size: size, chunk: d.downloadOptions.minPartSize()}, nil
+}
+
+// rangeStream is the stream returned by Open. It is not safe for concurrent
+// use.
+type rangeStream struct {
+	d      *Downloader
+	ctx    context.Context
+	cancel context.CancelFunc
+	url    string
+	// size is the size of the remote file, -1 when unknown.
+	size  int64
+	chunk int64
+	// offset is the position of the next byte read in the remote file.
+	offset int64
+	// body is the response of the range being read, of which end is the
+	// inclusive last byte or -1 when it runs to the end of the file.
+	body    io.ReadCloser
+	end     int64
+	attempt int
+	closed  bool
+}
+
+func (s *rangeStream) Read(p []byte) (int, error) {
+	if s.closed {
+		return 0, fmt.Errorf("error while reading %s: %w", s.url, os.ErrClosed)
+	}
+	for {
+		if s.size >= 0 && s.offset >= s.size {
+			return 0, io.EOF
+		}
+		if s.body == nil {
+			if err := s.next(); err != nil {
+				if s.size < 0 && s.offset > 0 && rangeComplete(err, s.offset) {
+					return 0, io.EOF
+				}
+				if err := s.retry(err); err != nil {
+					return 0, err
+				}
+				continue
+			}
+		}
+
+		n, err := s.body.Read(p)
+		s.offset += int64(n)
+		if n > 0 {
+			s.attempt = 0
+		}
+		if err == nil {
+			return n, nil
+		}
+		s.body.Close()
+		s.body = nil
+		if err == io.EOF {
+			if s.end < 0 && s.size < 0 {
+				return n, io.EOF
+			}
+			if s.end < 0 || s.offset > s.end {
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			err = io.ErrUnexpectedEOF
+		}
+		if err := s.retry(err); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// next requests the range starting at offset.
+func (s *rangeStream) next() error {
+	end := int64(-1)
+	if s.size >= 0 {
+		end = s.offset + s.chunk - 1
+		if end >= s.size {
+			end = s.size - 1
+		}
+	}
+	request, err := http.NewRequestWithContext(s.ctx, "GET", s.url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Range", s.d.rangeHeader(s.offset, end))
+	response, err := s.d.do(request)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(response.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(s.offset, 10)+"-") {
+			response.Body.Close()
+			return fmt.Errorf("%w: got %q for range %s of %s", ErrSizeChanged, response.Header.Get("Content-Range"), formatRange(s.offset, end), s.url)
+		}
+	case response.StatusCode == http.StatusOK && s.offset == 0:
+		// The range was ignored, the whole file follows.
+		end = -1
+	case response.StatusCode == http.StatusOK:
+		response.Body.Close()
+		return fmt.Errorf("%w: server ignored range %s, got : %v", ErrRangeNotSupported, formatRange(s.offset, end), response.StatusCode)
+	default:
+		defer response.Body.Close()
+		return newStatusError(response)
+	}
+	s.end = end
+	s.body = readCloser{Reader: s.d.pause.reader(s.ctx, response.Body, nil), Closer: response.Body}
+	return nil
+}
+
+// retry waits before the next attempt after err, or returns err once the
+// retries are used up or err is not worth another attempt.
+func (s *rangeStream) retry(err error) error {
+	if s.attempt >= s.d.downloadOptions.MaxRetries || !retryable(s.ctx, err) {
+		return fmt.Errorf("error while streaming %s at byte %d: %w", s.url, s.offset, err)
+	}
+	if err := sleepContext(s.ctx, s.d.retryWait(err, s.attempt)); err != nil {
+		return err
+	}
+	s.attempt++
+	return nil
+}
+
+// Close stops the stream, later reads fail.
+func (s *rangeStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.cancel()
+	if s.body != nil {
+		s.body.Close()
+		s.body = nil
+	}
+	return nil
+}
+
+// readCloser pairs a reader with the Closer of the body it reads.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
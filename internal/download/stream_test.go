@@ -0,0 +1,129 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	data := fixture(3<<20 + 123)
+	srv := newRangeServer(data)
+	defer srv.Close()
+
+	d := NewDownloader(DownloadOptions{MinPartSize: 1 << 20})
+	stream, err := d.Open(srv.URL + "/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	// Nothing is fetched before the first read.
+	if got := srv.requested(); len(got) != 0 {
+		t.Errorf("Open() requested %q before reading", got)
+	}
+
+	var got bytes.Buffer
+	buf := make([]byte, 1000)
+	for {
+		n, err := stream.Read(buf)
+		got.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("stream has %d bytes, want the %d of the file", got.Len(), len(data))
+	}
+	want := []string{"bytes=0-1048575", "bytes=1048576-2097151", "bytes=2097152-3145727", "bytes=3145728-3145850"}
+	if ranges := srv.requested(); !reflect.DeepEqual(ranges, want) {
+		t.Errorf("stream requested %q, want %q", ranges, want)
+	}
+
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Read(buf); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Read() after Close() error = %v, want os.ErrClosed", err)
+	}
+
+	missing := serve(nil)
+	defer missing.Close()
+	if _, err := d.Open(missing.URL + "/missing.bin"); !errors.Is(err, ErrUnexpectedStatus) {
+		t.Errorf("Open() of a missing file error = %v, want ErrUnexpectedStatus", err)
+	}
+}
+
+func TestOpenRetriesCutRange(t *testing.T) {
+	data := fixture(2 << 20)
+	var mu sync.Mutex
+	var ranges []string
+	// The first GET is cut off after 1000 bytes.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			first := len(ranges) == 1
+			mu.Unlock()
+			if first {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+				w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(data[:1000])
+				return
+			}
+		}
+		http.ServeContent(w, r, r.URL.Path, fixedModTime, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	d := noJitter(NewDownloader(DownloadOptions{MinPartSize: 4 << 20, MaxRetries: 1}))
+	stream, err := d.Open(srv.URL + "/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	got, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("stream has %d bytes, want the %d of the file", len(got), len(data))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"bytes=0-2097151", "bytes=1000-2097151"}; !reflect.DeepEqual(ranges, want) {
+		t.Errorf("stream requested %q, want the cut range again from byte 1000 %q", ranges, want)
+	}
+}
+
+func TestOpenRangesIgnored(t *testing.T) {
+	data := fixture(2 << 20)
+	// The whole file is sent for every range.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	}))
+	defer srv.Close()
+
+	stream, err := NewDownloader(DownloadOptions{MinPartSize: 1 << 20}).Open(srv.URL + "/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	got, err := io.ReadAll(stream)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("stream has %d bytes and %v, want the file in one response", len(got), err)
+	}
+}
@@ -0,0 +1,55 @@
+package download
+
+import (
+	"context"
+	"io"
+)
+
+// DownloadTo downloads url and writes it to w in order, e.g. to stream it
+// to stdout. The parts of a split file are staged in TempDir and copied to
+// w once all of them are done. Bytes already written to w are not undone
+// when the download fails. Options acting on local files, like Resume,
+// SkipExisting or ExtractArchives, are ignored.
+func (d *Downloader) DownloadTo(ctx context.Context, url string, w io.Writer) (DownloadResult, error) {
+	requests := urlRequests([]string{url})
+	requests[0].storage = writerStorage{w: w}
+	results, err := d.downloadFiles(ctx, requests)
+	return results[0], err
+}
+
+// writerStorage is the Storage of DownloadTo, it writes the single file
+// created in it to w. Nothing ever exists in it, renaming and removing are
+// no-ops.
+type writerStorage struct {
+	w io.Writer
+}
+
+func (s writerStorage) Create(name string) (io.WriteCloser, error) {
+	return nopWriteCloser{s.w}, nil
+}
+
+func (s writerStorage) Exists(name string) bool {
+	return false
+}
+
+func (s writerStorage) Rename(oldName, newName string) error {
+	return nil
+}
+
+func (s writerStorage) Remove(name string) error {
+	return nil
+}
+
+// nopWriteCloser hides every method of a writer but Write, so it is never
+// written at offsets.
+type nopWriteCloser struct {
+	w io.Writer
+}
+
+func (n nopWriteCloser) Write(p []byte) (int, error) {
+	return n.w.Write(p)
+}
+
+func (n nopWriteCloser) Close() error {
+	return nil
+}